@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/operation"
+	"github.com/bpineau/cloud-floating-ip/pkg/run"
+)
+
+var planFile string
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Make the route changes of a saved plan",
+	Long: `Make exactly the route changes of a plan saved by "plan --out", on behalf
+of the planned instance. Fails when a route no longer targets what the plan
+was computed from: all the routes are checked before the first change, and
+each one again right before its change. --ip isn't needed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		conf, err := readCfiConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if conf.PlanFile == "" {
+			log.Fatal("no plan file provided (--plan)")
+		}
+		run.Run(conf, operation.CfiApply)
+	},
+}
+
+func init() {
+	applyCmd.Flags().StringVar(&planFile, "plan", "", "plan file saved by the plan command")
+	bindFlag(applyCmd, "plan")
+
+	rootCmd.AddCommand(applyCmd)
+}
@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/daemon"
+	"github.com/bpineau/cloud-floating-ip/pkg/operation"
+	"github.com/bpineau/cloud-floating-ip/pkg/run"
+)
+
+// defaultPriority is the peer election priority of instances not setting --priority
+const defaultPriority = 100
+
+var (
+	role     string
+	interval time.Duration
+	rise     int
+	fall     int
+	release  bool
+	elect    string
+	priority int
+	advert   time.Duration
+	peers    []string
+	listen   string
+	authkey  string
+	leasedur time.Duration
+	kubecfg  string
+	kubens   string
+	kubelock string
+	kubenode string
+	releaseo bool
+	lockkey  string
+	etcds    []string
+	consul   string
+	nopreemp bool
+	home     string
+	failback time.Duration
+	startup  string
+	watchpre bool
+	handover string
+	minhold  time.Duration
+	maxfail  int
+	window   time.Duration
+	backoff  time.Duration
+	sbdetect bool
+	sbaction []string
+	vipiface string
+	alertcmd string
+	onstop   string
+	stoptime time.Duration
+	watchcfg bool
+	wlisten  string
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Continuously maintain the routes according to the instance's role",
+	Long: `Continuously maintain the routes according to the instance's role:
+a primary repairs routes that don't target the instance anymore, a standby
+only reports the routes state. With --election, the role is given by a leader
+election between instances. Stops on SIGINT or SIGTERM, reloads the
+configuration on SIGHUP.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := newCfiConfig()
+		if err := checkDaemonConfig(conf); err != nil {
+			log.Fatal(err)
+		}
+		conf.Reload = reloadDaemonConfig
+		if watchcfg {
+			watchConfig()
+		}
+		run.Run(conf, operation.CfiDaemon)
+	},
+}
+
+// checkDaemonConfig validates the daemon specific settings
+func checkDaemonConfig(conf *config.CfiConfig) error {
+	if conf.Election == "" && conf.Role != daemon.RolePrimary && conf.Role != daemon.RoleStandby {
+		return fmt.Errorf("unsupported role: '%s'", conf.Role)
+	}
+	if conf.Startup != daemon.StartupStandby && conf.Startup != daemon.StartupClaimUnowned {
+		return fmt.Errorf("unsupported startup policy: '%s'", conf.Startup)
+	}
+	if conf.OnStop != daemon.StopKeep && conf.OnStop != daemon.StopRelease && conf.OnStop != daemon.StopHandover {
+		return fmt.Errorf("unsupported stop policy: '%s'", conf.OnStop)
+	}
+	if conf.ExpectOwner != "" {
+		return fmt.Errorf("--expect-owner isn't supported by the daemon")
+	}
+	if conf.Election != "peer" && conf.Priority != defaultPriority {
+		return fmt.Errorf("--priority is only supported by the peer election")
+	}
+	if conf.Election != "peer" && conf.Home != "" {
+		return fmt.Errorf("--home is only supported by the peer election")
+	}
+	if conf.Election != "" && conf.Election != "peer" && conf.NoPreempt {
+		return fmt.Errorf("--nopreempt isn't supported by the %s election (its leader is never preempted)", conf.Election)
+	}
+
+	return nil
+}
+
+// reloadDaemonConfig re-reads the config file, and returns the new validated configuration
+func reloadDaemonConfig() (*config.CfiConfig, error) {
+	if err := viper.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read the config file: %v", err)
+	}
+
+	conf, err := loadCfiConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkDaemonConfig(conf); err != nil {
+		return nil, err
+	}
+
+	conf.Reload = reloadDaemonConfig
+
+	return conf, nil
+}
+
+// watchConfig sends us a SIGHUP (so the daemon reloads) when the config file changes
+func watchConfig() {
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			log.Printf("Failed to reload on %s change: %v\n", e.Name, err)
+		}
+	})
+	viper.WatchConfig()
+}
+
+func init() {
+	daemonCmd.Flags().StringVarP(&role, "role", "R", daemon.RoleStandby, "desired role (primary or standby)")
+	bindFlag(daemonCmd, "role")
+
+	daemonCmd.Flags().DurationVarP(&interval, "interval", "n", 30*time.Second, "delay between two routes checks")
+	bindFlag(daemonCmd, "interval")
+
+	daemonCmd.Flags().IntVar(&rise, "health-rise", 2, "consecutive successful health checks to become healthy")
+	bindFlag(daemonCmd, "health-rise")
+
+	daemonCmd.Flags().IntVar(&fall, "health-fall", 3, "consecutive failed health checks to become unhealthy")
+	bindFlag(daemonCmd, "health-fall")
+
+	daemonCmd.Flags().BoolVar(&release, "release-unhealthy", false, "delete the routes to the instance when unhealthy")
+	bindFlag(daemonCmd, "release-unhealthy")
+
+	daemonCmd.Flags().StringVarP(&elect, "election", "e", "", "leader election backend deciding the role (peer, cloud, kube, etcd or consul)")
+	bindFlag(daemonCmd, "election")
+
+	daemonCmd.Flags().IntVarP(&priority, "priority", "P", defaultPriority, "(peer election) instance priority (highest wins)")
+	bindFlag(daemonCmd, "priority")
+
+	daemonCmd.Flags().BoolVar(&nopreemp, "nopreempt", false, "(no or peer election) don't take over the IP from a live owner, even with a higher priority")
+	bindFlag(daemonCmd, "nopreempt")
+
+	daemonCmd.Flags().StringVar(&home, "home", "", "(peer election) preferred instance, taking the IP back after --failback-delay")
+	bindFlag(daemonCmd, "home")
+
+	daemonCmd.Flags().DurationVar(&failback, "failback-delay", time.Minute, "(peer election) how long the home instance must be eligible before taking the IP back")
+	bindFlag(daemonCmd, "failback-delay")
+
+	daemonCmd.Flags().StringVar(&startup, "startup", daemon.StartupStandby, "startup policy (standby or claim-unowned)")
+	bindFlag(daemonCmd, "startup")
+
+	daemonCmd.Flags().DurationVar(&advert, "advert-interval", time.Second, "(peer election) delay between two heartbeats")
+	bindFlag(daemonCmd, "advert-interval")
+
+	daemonCmd.Flags().StringSliceVar(&peers, "peers", nil, "(peer election) peers host:port (may be specified several times)")
+	bindFlag(daemonCmd, "peers")
+
+	daemonCmd.Flags().StringVar(&listen, "peer-listen", ":9876", "(peer election) host:port to receive heartbeats on")
+	bindFlag(daemonCmd, "peer-listen")
+
+	daemonCmd.Flags().StringVar(&authkey, "auth-key", "", "(peer election) secret key shared by peers")
+	bindFlag(daemonCmd, "auth-key")
+
+	daemonCmd.Flags().DurationVar(&leasedur, "lease-duration", 0, "election lease or session validity (default 30s for cloud, 15s otherwise)")
+	bindFlag(daemonCmd, "lease-duration")
+
+	daemonCmd.Flags().StringVar(&kubecfg, "kubeconfig", "", "(kube election) kubeconfig file (in-cluster config by default)")
+	bindFlag(daemonCmd, "kubeconfig")
+
+	daemonCmd.Flags().StringVar(&kubens, "kube-namespace", "default", "(kube election) namespace of the Lease")
+	bindFlag(daemonCmd, "kube-namespace")
+
+	daemonCmd.Flags().StringVar(&kubelock, "kube-lease", "", "(kube election) name of the Lease (default cloud-floating-ip-<ip>)")
+	bindFlag(daemonCmd, "kube-lease")
+
+	daemonCmd.Flags().StringVar(&kubenode, "kube-node", "", "(kube election) name of the node we run on")
+	bindFlag(daemonCmd, "kube-node")
+
+	daemonCmd.Flags().BoolVar(&releaseo, "release-on-loss", false, "delete the routes to the instance when losing the election")
+	bindFlag(daemonCmd, "release-on-loss")
+
+	daemonCmd.Flags().StringVar(&lockkey, "lock-key", "", "(etcd and consul elections) election key (default cloud-floating-ip/<ip>)")
+	bindFlag(daemonCmd, "lock-key")
+
+	daemonCmd.Flags().StringSliceVar(&etcds, "etcd-endpoints", nil, "(etcd election) etcd endpoints (may be specified several times)")
+	bindFlag(daemonCmd, "etcd-endpoints")
+
+	daemonCmd.Flags().StringVar(&consul, "consul-address", "", "(consul election) consul agent address (default from CONSUL_HTTP_ADDR, or 127.0.0.1:8500)")
+	bindFlag(daemonCmd, "consul-address")
+
+	daemonCmd.Flags().BoolVar(&watchpre, "watch-preemption", false, "release the IP on spot, autoscaling or GCE preemption notices")
+	bindFlag(daemonCmd, "watch-preemption")
+
+	daemonCmd.Flags().StringVar(&handover, "handover-to", "", "instance to route the IP to when releasing it voluntarily")
+	bindFlag(daemonCmd, "handover-to")
+
+	daemonCmd.Flags().StringVar(&onstop, "on-stop", daemon.StopKeep, "stop policy: keep the routes, release them, or handover to a standby")
+	bindFlag(daemonCmd, "on-stop")
+
+	daemonCmd.Flags().DurationVar(&stoptime, "stop-timeout", 30*time.Second, "how long to wait for the routes change on stop")
+	bindFlag(daemonCmd, "stop-timeout")
+
+	daemonCmd.Flags().DurationVar(&minhold, "min-hold", 0, "minimum delay between a state transition and a failover")
+	bindFlag(daemonCmd, "min-hold")
+
+	daemonCmd.Flags().IntVar(&maxfail, "max-failovers", 0, "maximum failovers per --failover-window (unlimited when 0)")
+	bindFlag(daemonCmd, "max-failovers")
+
+	daemonCmd.Flags().DurationVar(&window, "failover-window", time.Hour, "period over which failovers are counted")
+	bindFlag(daemonCmd, "failover-window")
+
+	daemonCmd.Flags().DurationVar(&backoff, "failover-backoff", 0, "initial delay between failovers, doubled on each failover in the window")
+	bindFlag(daemonCmd, "failover-backoff")
+
+	daemonCmd.Flags().BoolVar(&sbdetect, "detect-split-brain", false, "compare the local VIP with the routes and the peers reports")
+	bindFlag(daemonCmd, "detect-split-brain")
+
+	daemonCmd.Flags().StringSliceVar(&sbaction, "split-brain-action", nil, "action on split-brain: drop-vip, preempt or alert (may be specified several times)")
+	bindFlag(daemonCmd, "split-brain-action")
+
+	daemonCmd.Flags().StringVar(&vipiface, "vip-interface", "", "local interface carrying the VIP (default any)")
+	bindFlag(daemonCmd, "vip-interface")
+
+	daemonCmd.Flags().StringVar(&alertcmd, "alert-command", "", "command run on alerts (eg. split-brain)")
+	bindFlag(daemonCmd, "alert-command")
+
+	daemonCmd.Flags().StringVar(&wlisten, "witness-listen", "", "host:port to answer witness requests from peers on")
+	bindFlag(daemonCmd, "witness-listen")
+
+	daemonCmd.Flags().BoolVar(&watchcfg, "watch-config", false, "reload the configuration when the config file changes")
+
+	rootCmd.AddCommand(daemonCmd)
+}
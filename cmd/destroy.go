@@ -7,15 +7,32 @@ import (
 	"github.com/bpineau/cloud-floating-ip/pkg/run"
 )
 
+var (
+	all bool
+	yes bool
+)
+
 var destroyCmd = &cobra.Command{
 	Use:   "destroy",
 	Short: "Delete the routes managed by cloud-floating-ip",
-	Long:  `Delete the routes managed by cloud-floating-ip`,
+	Long: `Delete the routes to the IP targeting the instance. Routes targeting
+other instances are reported and left untouched, unless --all (or --force,
+which also overrides pins) is given. Asks for confirmation when run from a
+terminal, unless --yes is given.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		run.Run(newCfiConfig(), operation.CfiDestroy)
+		conf := newCfiConfig()
+		// --force deletes the routes we don't own too
+		conf.DestroyAll = conf.DestroyAll || conf.Force
+		run.Run(conf, operation.CfiDestroy)
 	},
 }
 
 func init() {
+	destroyCmd.Flags().BoolVar(&all, "all", false, "delete the routes targeting other instances too (implied by --force)")
+	bindFlag(destroyCmd, "all")
+
+	destroyCmd.Flags().BoolVarP(&yes, "yes", "y", false, "don't ask for confirmation")
+	bindFlag(destroyCmd, "yes")
+
 	rootCmd.AddCommand(destroyCmd)
 }
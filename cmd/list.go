@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/operation"
+	"github.com/bpineau/cloud-floating-ip/pkg/run"
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all the floating IPs routes in the VPC or network",
+	Long: `List all the floating IPs routes in the instance's VPC or network: /32
+routes in the (selected) AWS route tables, or cloud-floating-ip GCE routes.
+Displays each route target, the instance it resolves to, the tables it
+appears in, and whether all tables agree. --ip isn't needed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		conf, err := readCfiConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		run.Run(conf, operation.CfiList)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/operation"
+	"github.com/bpineau/cloud-floating-ip/pkg/run"
+)
+
+var (
+	from string
+	to   string
+)
+
+var moveCmd = &cobra.Command{
+	Use:   "move",
+	Short: "Route the IP address to another instance",
+	Long: `Route the IP address to the --to instance, from anywhere: the target
+interface is found as for preempt on that instance (--interface, --subnet or
+--target-ip select it on multihomed instances). With --from (like
+--expect-owner), the IP only moves when that instance (or route target)
+currently owns it. The routes are verified after the move. The previous
+owner isn't fenced, and connections are only drained (with --drain-timeout)
+when move runs on the current owner.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := newCfiConfig()
+		if conf.MoveTo == "" {
+			log.Fatal("no target instance provided (--to)")
+		}
+		run.Run(conf, operation.CfiMove)
+	},
+}
+
+func init() {
+	moveCmd.Flags().StringVar(&from, "from", "", "instance (or route target) expected to own the IP")
+	bindFlag(moveCmd, "from")
+
+	moveCmd.Flags().StringVar(&to, "to", "", "instance to route the IP to")
+	bindFlag(moveCmd, "to")
+
+	rootCmd.AddCommand(moveCmd)
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/operation"
+	"github.com/bpineau/cloud-floating-ip/pkg/run"
+)
+
+var (
+	reason   string
+	duration time.Duration
+)
+
+var pinCmd = &cobra.Command{
+	Use:   "pin",
+	Short: "Pin the IP address on the instance (eg. during maintenance)",
+	Long: `Pin the IP address on the instance: until unpinned (or until the pin
+expires), other instances won't preempt it, and daemons won't release it.
+The pin is stored along the routes (AWS route table tags, or GCE route).
+--force overrides the pins.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		run.Run(newCfiConfig(), operation.CfiPin)
+	},
+}
+
+var unpinCmd = &cobra.Command{
+	Use:   "unpin",
+	Short: "Remove the IP address maintenance pin",
+	Long:  `Remove the IP address maintenance pin`,
+	Run: func(cmd *cobra.Command, args []string) {
+		run.Run(newCfiConfig(), operation.CfiUnpin)
+	},
+}
+
+func init() {
+	pinCmd.Flags().StringVar(&reason, "reason", "", "why the IP is pinned")
+	bindFlag(pinCmd, "reason")
+
+	pinCmd.Flags().DurationVar(&duration, "duration", 0, "pin validity (until unpinned by default)")
+	bindFlag(pinCmd, "duration")
+
+	rootCmd.AddCommand(pinCmd)
+	rootCmd.AddCommand(unpinCmd)
+}
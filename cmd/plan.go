@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/operation"
+	"github.com/bpineau/cloud-floating-ip/pkg/run"
+)
+
+var (
+	planDestroy bool
+	planOut     string
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Display (and save) the route changes a preempt would make",
+	Long: `Display the route changes a preempt (or destroy, with --destroy) would
+make, per route table or network, with the route targets before and after,
+without making them. With --out, the plan is saved to a file for the apply
+command. Fencing isn't planned.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		run.Run(newCfiConfig(), operation.CfiPlan)
+	},
+}
+
+func init() {
+	planCmd.Flags().BoolVar(&planDestroy, "destroy", false, "plan a destroy rather than a preempt")
+	bindFlag(planCmd, "destroy")
+
+	planCmd.Flags().StringVar(&planOut, "out", "", "file to save the plan to")
+	bindFlag(planCmd, "out")
+
+	rootCmd.AddCommand(planCmd)
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/operation"
+	"github.com/bpineau/cloud-floating-ip/pkg/run"
+)
+
+var owner string
+
+var repairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Make all the route tables agree on the IP target",
+	Long: `Make all the route tables agree on the IP target: route the IP to the
+target most tables currently route it to (or to the --owner instance) in the
+tables that disagree. status reports disagreeing tables as "partial".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		run.Run(newCfiConfig(), operation.CfiRepair)
+	},
+}
+
+func init() {
+	repairCmd.Flags().StringVar(&owner, "owner", "", "instance to route the IP to (default the majority target)")
+	bindFlag(repairCmd, "owner")
+
+	rootCmd.AddCommand(repairCmd)
+}
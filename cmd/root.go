@@ -1,10 +1,12 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -31,36 +33,138 @@ var (
 	subnet   string
 	targetip string
 	tables   []string
+	notifyp  string
+	notifys  string
+	notifyf  string
+	notifyt  time.Duration
+	notify   bool
+	fence    []string
+	fencet   time.Duration
+	force    bool
+	witness  []string
+	quorum   int
+	drainc   string
+	drainp   string
+	draint   int
+	draintm  time.Duration
+	expowner string
 )
 
 func newCfiConfig() *config.CfiConfig {
-	conf := &config.CfiConfig{
-		IP:            viper.GetString("ip"),
-		Hoster:        viper.GetString("hoster"),
-		Instance:      viper.GetString("instance"),
-		DryRun:        viper.GetBool("dry-run"),
-		Quiet:         viper.GetBool("quiet"),
-		Project:       viper.GetString("project"),
-		Region:        viper.GetString("region"),
-		Zone:          viper.GetString("zone"),
-		NoMain:        viper.GetBool("ignore-main-table"),
-		Iface:         viper.GetString("interface"),
-		Subnet:        viper.GetString("subnet"),
-		TargetIP:      viper.GetString("target-ip"),
-		RouteTables:   viper.GetStringSlice("table"),
-		AwsAccesKeyID: viper.GetString("aws-access-key-id"),
-		AwsSecretKey:  viper.GetString("aws-secret-key"),
+	conf, err := loadCfiConfig()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	if conf.Hoster != "" && conf.Hoster != "gce" && conf.Hoster != "aws" {
-		log.Fatalf("Unsupported hosting provider: '%s\n'", conf.Hoster)
+	return conf
+}
+
+// loadCfiConfig returns the validated configuration, from flags, environment and config file
+func loadCfiConfig() (*config.CfiConfig, error) {
+	conf, err := readCfiConfig()
+	if err != nil {
+		return nil, err
 	}
 
 	if conf.IP == "" {
-		log.Fatalf("No IP provided")
+		return nil, errors.New("no IP provided")
 	}
 
-	return conf
+	return conf, nil
+}
+
+// readCfiConfig returns the configuration, which may lack an IP (eg. to list all the IPs)
+func readCfiConfig() (*config.CfiConfig, error) {
+	conf := &config.CfiConfig{
+		IP:                viper.GetString("ip"),
+		Hoster:            viper.GetString("hoster"),
+		Instance:          viper.GetString("instance"),
+		DryRun:            viper.GetBool("dry-run"),
+		Quiet:             viper.GetBool("quiet"),
+		Project:           viper.GetString("project"),
+		Region:            viper.GetString("region"),
+		Zone:              viper.GetString("zone"),
+		NoMain:            viper.GetBool("ignore-main-table"),
+		Iface:             viper.GetString("interface"),
+		Subnet:            viper.GetString("subnet"),
+		TargetIP:          viper.GetString("target-ip"),
+		RouteTables:       viper.GetStringSlice("table"),
+		AwsAccesKeyID:     viper.GetString("aws-access-key-id"),
+		AwsSecretKey:      viper.GetString("aws-secret-key"),
+		Role:              viper.GetString("role"),
+		Interval:          viper.GetDuration("interval"),
+		HealthRise:        viper.GetInt("health-rise"),
+		HealthFall:        viper.GetInt("health-fall"),
+		ReleaseUnhealthy:  viper.GetBool("release-unhealthy"),
+		Election:          viper.GetString("election"),
+		Priority:          viper.GetInt("priority"),
+		NoPreempt:         viper.GetBool("nopreempt"),
+		Home:              viper.GetString("home"),
+		FailbackDelay:     viper.GetDuration("failback-delay"),
+		Startup:           viper.GetString("startup"),
+		AdvertInterval:    viper.GetDuration("advert-interval"),
+		Peers:             viper.GetStringSlice("peers"),
+		PeerListen:        viper.GetString("peer-listen"),
+		AuthKey:           viper.GetString("auth-key"),
+		LeaseDuration:     viper.GetDuration("lease-duration"),
+		ReleaseOnLoss:     viper.GetBool("release-on-loss"),
+		LockKey:           viper.GetString("lock-key"),
+		EtcdEndpoints:     viper.GetStringSlice("etcd-endpoints"),
+		ConsulAddress:     viper.GetString("consul-address"),
+		KubeConfig:        viper.GetString("kubeconfig"),
+		KubeNamespace:     viper.GetString("kube-namespace"),
+		KubeLease:         viper.GetString("kube-lease"),
+		KubeNode:          viper.GetString("kube-node"),
+		WatchPreemption:   viper.GetBool("watch-preemption"),
+		HandoverTo:        viper.GetString("handover-to"),
+		OnStop:            viper.GetString("on-stop"),
+		StopTimeout:       viper.GetDuration("stop-timeout"),
+		NotifyPrimary:     viper.GetString("notify-primary"),
+		NotifyStandby:     viper.GetString("notify-standby"),
+		NotifyFault:       viper.GetString("notify-fault"),
+		NotifyTimeout:     viper.GetDuration("notify-timeout"),
+		Notify:            viper.GetBool("notify"),
+		MinHold:           viper.GetDuration("min-hold"),
+		MaxFailovers:      viper.GetInt("max-failovers"),
+		FailoverWindow:    viper.GetDuration("failover-window"),
+		FailoverBackoff:   viper.GetDuration("failover-backoff"),
+		Fence:             viper.GetStringSlice("fence"),
+		DetectSplitBrain:  viper.GetBool("detect-split-brain"),
+		SplitBrainActions: viper.GetStringSlice("split-brain-action"),
+		VIPInterface:      viper.GetString("vip-interface"),
+		AlertCommand:      viper.GetString("alert-command"),
+		FenceTimeout:      viper.GetDuration("fence-timeout"),
+		Force:             viper.GetBool("force"),
+		Witnesses:         viper.GetStringSlice("witness"),
+		Quorum:            viper.GetInt("quorum"),
+		DrainCommand:      viper.GetString("drain-command"),
+		DrainProbe:        viper.GetString("drain-probe"),
+		DrainThreshold:    viper.GetInt("drain-threshold"),
+		DrainTimeout:      viper.GetDuration("drain-timeout"),
+		WitnessListen:     viper.GetString("witness-listen"),
+		JSON:              viper.GetBool("json"),
+		RepairOwner:       viper.GetString("owner"),
+		ExpectOwner:       viper.GetString("expect-owner"),
+		DestroyAll:        viper.GetBool("all"),
+		AssumeYes:         viper.GetBool("yes"),
+		MoveFrom:          viper.GetString("from"),
+		MoveTo:            viper.GetString("to"),
+		PlanDestroy:       viper.GetBool("destroy"),
+		PlanOut:           viper.GetString("out"),
+		PlanFile:          viper.GetString("plan"),
+		PinReason:         viper.GetString("reason"),
+		PinDuration:       viper.GetDuration("duration"),
+	}
+
+	if err := viper.UnmarshalKey("health-checks", &conf.HealthChecks); err != nil {
+		return nil, fmt.Errorf("invalid health-checks configuration: %v", err)
+	}
+
+	if conf.Hoster != "" && conf.Hoster != "gce" && conf.Hoster != "aws" {
+		return nil, fmt.Errorf("unsupported hosting provider: '%s'", conf.Hoster)
+	}
+
+	return conf, nil
 }
 
 // rootCmd represents the base command when called without any subcommands
@@ -91,6 +195,12 @@ func bindPFlag(key string, cmd string) {
 	}
 }
 
+func bindFlag(cmd *cobra.Command, key string) {
+	if err := viper.BindPFlag(key, cmd.Flags().Lookup(key)); err != nil {
+		log.Fatal("Failed to bind cli argument:", err)
+	}
+}
+
 func init() {
 	cobra.OnInitialize(initConfig)
 
@@ -141,6 +251,51 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVarP(&secretk, "aws-secret-key", "k", "", "(AWS) secret key")
 	bindPFlag("aws-secret-key", "aws-secret-key")
+
+	rootCmd.PersistentFlags().StringSliceVar(&fence, "fence", nil, "fence the previous owner before a daemon failover: stop, detach (AWS) or source-dest-check (AWS) (may be specified several times)")
+	bindPFlag("fence", "fence")
+
+	rootCmd.PersistentFlags().DurationVar(&fencet, "fence-timeout", 2*time.Minute, "fencing timeout")
+	bindPFlag("fence-timeout", "fence-timeout")
+
+	rootCmd.PersistentFlags().StringVar(&notifyp, "notify-primary", "", "command run when the instance becomes primary")
+	bindPFlag("notify-primary", "notify-primary")
+
+	rootCmd.PersistentFlags().StringVar(&notifys, "notify-standby", "", "command run when the instance becomes standby")
+	bindPFlag("notify-standby", "notify-standby")
+
+	rootCmd.PersistentFlags().StringVar(&notifyf, "notify-fault", "", "command run when the instance becomes unhealthy or is terminated")
+	bindPFlag("notify-fault", "notify-fault")
+
+	rootCmd.PersistentFlags().DurationVar(&notifyt, "notify-timeout", 30*time.Second, "notify commands timeout")
+	bindPFlag("notify-timeout", "notify-timeout")
+
+	rootCmd.PersistentFlags().BoolVar(&notify, "notify", false, "run notify commands after preempt and destroy too")
+	bindPFlag("notify", "notify")
+
+	rootCmd.PersistentFlags().BoolVar(&force, "force", false, "ignore maintenance pins and witnesses (destroy: implies --all)")
+	bindPFlag("force", "force")
+
+	rootCmd.PersistentFlags().StringSliceVar(&witness, "witness", nil, "witness confirming the owner is down before preempting: cloud, or a peer host:port (may be specified several times)")
+	bindPFlag("witness", "witness")
+
+	rootCmd.PersistentFlags().IntVar(&quorum, "quorum", 0, "witnesses votes needed to preempt (default majority)")
+	bindPFlag("quorum", "quorum")
+
+	rootCmd.PersistentFlags().StringVar(&expowner, "expect-owner", "", "only preempt, destroy or move when this instance (or route target, or none) owns the IP")
+	bindPFlag("expect-owner", "expect-owner")
+
+	rootCmd.PersistentFlags().StringVar(&drainc, "drain-command", "", "command run to stop accepting new connections before voluntarily moving the IP away")
+	bindPFlag("drain-command", "drain-command")
+
+	rootCmd.PersistentFlags().StringVar(&drainp, "drain-probe", "", "command printing the number of connections left to drain (default counts the IP's conntrack entries)")
+	bindPFlag("drain-probe", "drain-probe")
+
+	rootCmd.PersistentFlags().IntVar(&draint, "drain-threshold", 0, "the IP is drained once at most this many connections are left")
+	bindPFlag("drain-threshold", "drain-threshold")
+
+	rootCmd.PersistentFlags().DurationVar(&draintm, "drain-timeout", 0, "how long to wait for connections to drain before voluntarily moving the IP away (no draining when 0)")
+	bindPFlag("drain-timeout", "drain-timeout")
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -7,16 +7,25 @@ import (
 	"github.com/bpineau/cloud-floating-ip/pkg/run"
 )
 
+var jsonout bool
+
 var statusCmd = &cobra.Command{
 	Use:   "status",
-	Short: "Display the status of the instance (owner or standby)",
+	Short: "Display the status of the instance (owner, partial or standby)",
 	Long: `Display the status of the instance:
-owner when the floating IP address route to the instance, standby otherwise.`,
+owner when the floating IP address route to the instance, partial when the
+route tables disagree (exiting with code 3), standby otherwise. Then displays
+the current owner, the route target, the network, the route state in each
+table (absent, foreign, us or blackhole), the tables diverging from the
+majority target, and the route last modification date when available (GCE).`,
 	Run: func(cmd *cobra.Command, args []string) {
 		run.Run(newCfiConfig(), operation.CfiStatus)
 	},
 }
 
 func init() {
+	statusCmd.Flags().BoolVar(&jsonout, "json", false, "display the status as JSON")
+	bindFlag(statusCmd, "json")
+
 	rootCmd.AddCommand(statusCmd)
 }
@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 // CfiConfig is the configuration structucture
 type CfiConfig struct {
 	// IP is the address we will target routes at. Only mandatory and non guessable argument.
@@ -46,4 +48,223 @@ type CfiConfig struct {
 
 	// AwsSecretKey (AWS only) is the secret key to use (if we don't use an instance profile's role)
 	AwsSecretKey string
+
+	// Role is the state the daemon maintains (primary or standby)
+	Role string
+
+	// Interval between two daemon reconciliation loops
+	Interval time.Duration
+
+	// HealthChecks are local probes gating the IP ownership
+	HealthChecks []HealthCheck
+
+	// HealthRise is the number of consecutive successful checks to become healthy
+	HealthRise int
+
+	// HealthFall is the number of consecutive failed checks to become unhealthy
+	HealthFall int
+
+	// ReleaseUnhealthy removes the routes to the instance when it becomes unhealthy
+	ReleaseUnhealthy bool
+
+	// Election is the leader election backend deciding the daemon's role (none by default)
+	Election string
+
+	// Priority of the instance in the election (highest wins)
+	Priority int
+
+	// NoPreempt prevents taking over the IP from a live owner, even with a higher priority
+	NoPreempt bool
+
+	// Home is the preferred instance, taking the IP back once healthy for FailbackDelay
+	Home string
+
+	// FailbackDelay is how long the home instance must stay eligible before taking the IP back
+	FailbackDelay time.Duration
+
+	// Startup is the daemon's startup policy (standby or claim-unowned)
+	Startup string
+
+	// AdvertInterval is the delay between two peer heartbeats
+	AdvertInterval time.Duration
+
+	// Peers are the host:port UDP addresses of the other instances (peer election)
+	Peers []string
+
+	// PeerListen is the host:port UDP address we receive heartbeats on (peer election)
+	PeerListen string
+
+	// AuthKey is the secret shared by peers to sign their heartbeats (peer election)
+	AuthKey string
+
+	// LeaseDuration is the validity of the election lease or session
+	LeaseDuration time.Duration
+
+	// ReleaseOnLoss removes the routes to the instance when it loses the election
+	ReleaseOnLoss bool
+
+	// LockKey is the election key (etcd and consul elections)
+	LockKey string
+
+	// EtcdEndpoints are the etcd cluster members URLs (etcd election)
+	EtcdEndpoints []string
+
+	// ConsulAddress is the consul agent address (consul election)
+	ConsulAddress string
+
+	// KubeConfig is the kubeconfig file path (kube election, in-cluster config when empty)
+	KubeConfig string
+
+	// KubeNamespace is the namespace of the Lease (kube election)
+	KubeNamespace string
+
+	// KubeLease is the name of the Lease (kube election)
+	KubeLease string
+
+	// KubeNode is the name of the node we run on, whose providerID gives the instance (kube election)
+	KubeNode string
+
+	// WatchPreemption releases the IP when the instance gets a termination notice (spot, ASG, GCE preemption)
+	WatchPreemption bool
+
+	// HandoverTo is the instance we route the IP to when releasing it voluntarily
+	HandoverTo string
+
+	// OnStop is the daemon's stop policy (keep, release or handover)
+	OnStop string
+
+	// StopTimeout bounds the wait for the routes change on stop
+	StopTimeout time.Duration
+
+	// NotifyPrimary is a command run when the instance becomes primary
+	NotifyPrimary string
+
+	// NotifyStandby is a command run when the instance becomes standby
+	NotifyStandby string
+
+	// NotifyFault is a command run when the instance becomes unhealthy, or is about to be terminated
+	NotifyFault string
+
+	// NotifyTimeout bounds the hooks duration
+	NotifyTimeout time.Duration
+
+	// MinHold is the minimum delay between a state transition and a failover
+	MinHold time.Duration
+
+	// MaxFailovers is the maximum number of failovers per FailoverWindow (unlimited when zero)
+	MaxFailovers int
+
+	// FailoverWindow is the period over which failovers are counted
+	FailoverWindow time.Duration
+
+	// FailoverBackoff is the initial delay between failovers, doubled on each failover in the window
+	FailoverBackoff time.Duration
+
+	// DetectSplitBrain compares the local VIP state with the routes and the peers reports
+	DetectSplitBrain bool
+
+	// SplitBrainActions are run when a split-brain is detected (drop-vip, preempt, alert)
+	SplitBrainActions []string
+
+	// VIPInterface is the local interface carrying the VIP (any interface when empty)
+	VIPInterface string
+
+	// AlertCommand is run on alerts (eg. split-brain), with the problem in CFI_REASON
+	AlertCommand string
+
+	// Fence lists the actions run against the previous owner before we take over (stop, detach, source-dest-check)
+	Fence []string
+
+	// FenceTimeout bounds the fencing duration
+	FenceTimeout time.Duration
+
+	// Notify runs the hooks after one-shot preempt and destroy operations too
+	Notify bool
+
+	// Force ignores maintenance pins and the witnesses quorum
+	Force bool
+
+	// Witnesses are asked whether the owner is down before we take over ("cloud", or peers host:port)
+	Witnesses []string
+
+	// Quorum is the number of witnesses that must confirm the owner is down (majority by default)
+	Quorum int
+
+	// WitnessListen is the host:port the daemon answers witness requests on (disabled when empty)
+	WitnessListen string
+
+	// DrainCommand is run to stop accepting new connections before we voluntarily move the IP
+	DrainCommand string
+
+	// DrainProbe is a command printing the number of connections left (conntrack entries by default)
+	DrainProbe string
+
+	// DrainThreshold is the maximum connections count left for the IP to be drained
+	DrainThreshold int
+
+	// DrainTimeout bounds the wait for connections to drain (draining is disabled when zero)
+	DrainTimeout time.Duration
+
+	// JSON displays the status as JSON
+	JSON bool
+
+	// RepairOwner is the instance the repair command routes the IP to (the majority target when empty)
+	RepairOwner string
+
+	// ExpectOwner is the instance (or route target, or "none") that must own the IP for preempt, destroy and move to change it
+	ExpectOwner string
+
+	// DestroyAll deletes the routes to the IP targeting other instances too (destroy command)
+	DestroyAll bool
+
+	// AssumeYes skips the confirmation prompt (destroy command)
+	AssumeYes bool
+
+	// MoveFrom is the instance expected to own the IP before a move (any when empty)
+	MoveFrom string
+
+	// MoveTo is the instance a move routes the IP to
+	MoveTo string
+
+	// PlanDestroy plans a destroy rather than a preempt (plan command)
+	PlanDestroy bool
+
+	// PlanOut is the file the plan command saves the plan to (only printed when empty)
+	PlanOut string
+
+	// PlanFile is the plan the apply command makes the changes of
+	PlanFile string
+
+	// PinReason explains why the IP is pinned (pin command)
+	PinReason string
+
+	// PinDuration is the pin validity (pin command, until unpinned when zero)
+	PinDuration time.Duration
+
+	// Reload re-reads and validates the configuration (daemon only, on SIGHUP)
+	Reload func() (*CfiConfig, error)
+}
+
+// HealthCheck describes a local probe (tcp, http or exec)
+type HealthCheck struct {
+	// Type is the kind of probe: tcp, http or exec
+	Type string
+
+	// Address (tcp only) is the host:port we should be able to connect to
+	Address string
+
+	// URL (http only) is the address we'll GET
+	URL string
+
+	// ExpectStatus (http only) is the expected HTTP status code (defaults to 200)
+	ExpectStatus int `mapstructure:"expect-status"`
+
+	// ExpectBody (http only) is a regexp the response body must match
+	ExpectBody string `mapstructure:"expect-body"`
+
+	// Command (exec only) is a shell command that must exit with a zero code
+	Command string
+
+	// Timeout bounds the probe duration (defaults to 5s)
+	Timeout time.Duration
 }
@@ -0,0 +1,762 @@
+// Package daemon keeps the floating IP routes in the desired state,
+// periodically repairing any drift (eg. routes modified by hand).
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/damping"
+	"github.com/bpineau/cloud-floating-ip/pkg/drain"
+	"github.com/bpineau/cloud-floating-ip/pkg/election"
+	"github.com/bpineau/cloud-floating-ip/pkg/health"
+	"github.com/bpineau/cloud-floating-ip/pkg/hoster"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+	"github.com/bpineau/cloud-floating-ip/pkg/notice"
+	"github.com/bpineau/cloud-floating-ip/pkg/notify"
+	"github.com/bpineau/cloud-floating-ip/pkg/pin"
+	"github.com/bpineau/cloud-floating-ip/pkg/quorum"
+	"github.com/bpineau/cloud-floating-ip/pkg/splitbrain"
+)
+
+const (
+	// RolePrimary daemons make sure the floating IP routes to the instance
+	RolePrimary = "primary"
+
+	// RoleStandby daemons only watch and report the routes state
+	RoleStandby = "standby"
+
+	// StateFault is the state of an instance failing its health checks
+	StateFault = "fault"
+
+	// StateLeaving is the state of an instance that got a termination notice
+	StateLeaving = "leaving"
+
+	// StartupStandby daemons never preempt the IP on their first check (but
+	// primaries without election claim it when nobody owns it)
+	StartupStandby = "standby"
+
+	// StartupClaimUnowned daemons preempt the IP on their first check when nobody owns it
+	StartupClaimUnowned = "claim-unowned"
+
+	// StopKeep daemons leave the routes untouched when stopping
+	StopKeep = "keep"
+
+	// StopRelease daemons delete the routes to their instance when stopping
+	StopRelease = "release"
+
+	// StopHandover daemons route the IP to a standby when stopping
+	StopHandover = "handover"
+
+	defaultInterval    = 30 * time.Second
+	defaultStopTimeout = 30 * time.Second
+	stopPollInterval   = 2 * time.Second
+)
+
+// Daemon runs a reconciliation loop over an initialized hoster
+type Daemon struct {
+	conf     *config.CfiConfig
+	hoster   hoster.Hoster
+	log      log.Logger
+	health   *health.Checker
+	elector  election.Elector
+	watcher  notice.Watcher
+	handover hoster.Hoster
+	notifier *notify.Notifier
+	damper   *damping.Damper
+	detector *splitbrain.Detector
+	quorum   *quorum.Checker
+	drainer  *drain.Drainer
+	elected  bool
+	started  bool
+	notice   string
+	state    string
+	ok       bool
+
+	changed     chan bool
+	notices     chan string
+	electErr    chan error
+	stopElect   context.CancelFunc
+	stopWatcher context.CancelFunc
+	stopWitness context.CancelFunc
+	witnessed   chan struct{}
+}
+
+// New returns a daemon acting on an initialized hoster
+func New(conf *config.CfiConfig, h hoster.Hoster, logger log.Logger) (*Daemon, error) {
+	checker, err := health.NewChecker(conf, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Daemon{
+		conf:     conf,
+		hoster:   h,
+		log:      logger,
+		health:   checker,
+		notifier: notify.New(conf, hoster.Name(h), logger),
+		damper:   damping.New(conf),
+	}
+	d.drainer = drain.New(conf, d.notifier, logger)
+
+	if conf.Election != "" {
+		d.elector, err = election.GetElector(conf.Election)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = d.elector.Init(conf, h, logger); err != nil {
+			return nil, fmt.Errorf("failed to initialize %s election: %v", conf.Election, err)
+		}
+	}
+
+	if conf.WatchPreemption {
+		d.watcher, err = notice.GuessWatcher(conf.Hoster)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = d.watcher.Init(conf, logger); err != nil {
+			return nil, fmt.Errorf("failed to watch termination notices: %v", err)
+		}
+	}
+
+	if conf.DetectSplitBrain {
+		d.detector, err = splitbrain.New(conf, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d.quorum, err = quorum.New(conf, h, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.HandoverTo != "" {
+		d.handover, err = hoster.ForInstance(h, conf, conf.HandoverTo, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare the handover to %s: %v", conf.HandoverTo, err)
+		}
+	}
+
+	return d, nil
+}
+
+// Run reconciles routes every conf.Interval, until we receive SIGINT or SIGTERM.
+// SIGHUP reloads the configuration.
+func (d *Daemon) Run() error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	defer signal.Stop(hups)
+
+	ticker := time.NewTicker(d.interval())
+	defer func() { ticker.Stop() }()
+
+	d.changed = make(chan bool, 1)
+	d.notices = make(chan string, 1)
+
+	if err := d.witness(); err != nil {
+		return err
+	}
+	defer d.unwitness()
+
+	d.watch()
+	d.campaign()
+
+	if d.elector != nil {
+		d.log.Infof("Starting with %s election for %s, checking routes every %s\n",
+			d.conf.Election, d.conf.IP, d.interval())
+	} else {
+		d.log.Infof("Starting as %s for %s, checking routes every %s\n",
+			d.conf.Role, d.conf.IP, d.interval())
+	}
+
+	watchdog, stopWatchdog := d.watchdog()
+	defer stopWatchdog()
+
+	d.sdNotify("READY=1")
+	d.reconcile()
+
+	for {
+		select {
+		case sig := <-sigs:
+			d.log.Infof("Received %s, stopping\n", sig)
+			d.sdNotify("STOPPING=1")
+			return d.stop()
+		case sig := <-hups:
+			d.log.Infof("Received %s, reloading the configuration\n", sig)
+			d.sdNotify("RELOADING=1")
+			if d.reload() {
+				ticker.Stop()
+				ticker = time.NewTicker(d.interval())
+				d.reconcile()
+			}
+			d.sdNotify("READY=1")
+		case <-watchdog:
+			if d.ok {
+				d.sdNotify("WATCHDOG=1")
+			}
+		case err := <-d.electErr:
+			return fmt.Errorf("%s election failed: %v", d.conf.Election, err)
+		case <-d.changed:
+			d.reconcile()
+		case reason := <-d.notices:
+			d.noticed(reason)
+			d.reconcile()
+		case <-ticker.C:
+			d.reconcile()
+		}
+	}
+}
+
+// stop gives the IP away as per the stop policy, then ends the election
+// (if any), giving the elector a chance to resign
+func (d *Daemon) stop() error {
+	d.giveAway()
+	d.unwatch()
+
+	return d.resign()
+}
+
+// interval returns the delay between two reconciliations
+func (d *Daemon) interval() time.Duration {
+	if d.conf.Interval <= 0 {
+		return defaultInterval
+	}
+
+	return d.conf.Interval
+}
+
+// campaign runs the election (if any) in the background, until resign is called
+func (d *Daemon) campaign() {
+	if d.elector == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	elector, changed := d.elector, d.changed
+
+	go func() { errc <- elector.Run(ctx, changed) }()
+
+	d.stopElect, d.electErr = cancel, errc
+}
+
+// resign ends the running election (if any), and returns its outcome
+func (d *Daemon) resign() error {
+	if d.stopElect == nil {
+		return nil
+	}
+
+	d.stopElect()
+	err := <-d.electErr
+	d.stopElect, d.electErr = nil, nil
+
+	return err
+}
+
+// watch forwards termination notices in the background, until unwatch is called
+func (d *Daemon) watch() {
+	if d.watcher == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watcher, notices := d.watcher, d.notices
+
+	go func() {
+		if err := watcher.Watch(ctx, notices); err != nil && ctx.Err() == nil {
+			d.log.Errorf("Stopped watching termination notices: %v\n", err)
+		}
+	}()
+
+	d.stopWatcher = cancel
+}
+
+// unwatch stops watching termination notices
+func (d *Daemon) unwatch() {
+	if d.stopWatcher != nil {
+		d.stopWatcher()
+		d.stopWatcher = nil
+	}
+}
+
+// witness answers other instances asking whether an owner is down (when
+// conf.WitnessListen is set), in the background until unwitness is called
+func (d *Daemon) witness() error {
+	if d.conf.WitnessListen == "" {
+		return nil
+	}
+
+	srv, err := quorum.NewServer(d.conf, d.log, judge(d.elector, d.hoster))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		if err := srv.Serve(ctx); err != nil {
+			d.log.Errorf("Stopped answering witness requests: %v\n", err)
+		}
+	}()
+
+	d.stopWitness, d.witnessed = cancel, done
+
+	return nil
+}
+
+// unwitness stops answering witness requests, and waits until the server is closed
+func (d *Daemon) unwitness() {
+	if d.stopWitness != nil {
+		d.stopWitness()
+		<-d.witnessed
+		d.stopWitness, d.witnessed = nil, nil
+	}
+}
+
+// judge returns a witness vote function: an owner is down when it isn't a
+// live master in our election, or (for unknown instances) per the cloud API
+func judge(elector election.Elector, h hoster.Hoster) quorum.Judge {
+	return func(owner string) (bool, string, error) {
+		if obs, ok := elector.(election.Observer); ok {
+			if master, known := obs.Master(path.Base(owner)); known {
+				if master {
+					return false, "live master in election", nil
+				}
+				return true, "not a live master in election", nil
+			}
+		}
+
+		healthy, err := h.InstanceHealthy(owner)
+		if err != nil {
+			return false, "", err
+		}
+
+		if !healthy {
+			return true, "instance isn't running, or is impaired", nil
+		}
+
+		return false, "instance is running", nil
+	}
+}
+
+// role returns the desired role, as configured or elected
+func (d *Daemon) role() string {
+	if d.elector == nil {
+		return d.conf.Role
+	}
+
+	if d.elector.Leader() {
+		return RolePrimary
+	}
+
+	return RoleStandby
+}
+
+// reconcile compares the routes state with the desired role, and repair drifts
+func (d *Daemon) reconcile() {
+	first := !d.started
+	d.started = true
+	d.ok = true
+
+	if d.notice != "" {
+		d.leave()
+		return
+	}
+
+	owner := d.hoster.Status()
+	healthy := d.health.Check()
+
+	if d.elector != nil {
+		d.elector.SetEligible(healthy)
+	}
+
+	if !healthy {
+		d.transition(StateFault)
+		if owner && d.conf.ReleaseUnhealthy {
+			d.release("Instance is unhealthy")
+			owner = d.hoster.Status()
+		}
+		d.checkSplitBrain(owner, "", false)
+		return
+	}
+
+	role := d.role()
+	if d.lostElection(role) && owner && d.conf.ReleaseOnLoss {
+		d.release("Lost the election")
+		owner = d.hoster.Status()
+	}
+
+	state := RoleStandby
+	if owner {
+		state = RolePrimary
+	}
+
+	d.transition(state)
+
+	claim := state != RolePrimary && d.shouldClaim(role, first)
+
+	if d.checkSplitBrain(owner, role, claim) || !claim {
+		return
+	}
+
+	d.preempt(role)
+}
+
+// preempt fences the previous owner then takes over the routes, unless the
+// IP is pinned to another instance, failovers are damped, or we're fenced
+func (d *Daemon) preempt(role string) {
+	if err := d.pinned(d.conf.Instance); err != nil {
+		d.log.Infof("Not preempting %s: %v\n", d.conf.IP, err)
+		return
+	}
+
+	if err := d.damper.Allow(time.Now()); err != nil {
+		d.log.Warnf("Not preempting %s, damping failovers: %v\n", d.conf.IP, err)
+		return
+	}
+
+	if err := d.quorum.Confirm(); err != nil {
+		d.log.Warnf("Not preempting %s: %v\n", d.conf.IP, err)
+		return
+	}
+
+	if f, ok := d.elector.(election.Fencer); ok && role == RolePrimary {
+		if err := f.Fence(); err != nil {
+			d.log.Errorf("Not preempting %s: %v\n", d.conf.IP, err)
+			return
+		}
+	}
+
+	if err := d.hoster.Fence(); err != nil {
+		d.log.Errorf("Not preempting %s, failed to fence the previous owner: %v\n", d.conf.IP, err)
+		return
+	}
+
+	d.log.Infof("Routes to %s don't target this instance, repairing\n", d.conf.IP)
+	d.damper.Failover(time.Now())
+
+	if err := d.hoster.Preempt(); err != nil {
+		d.fail("Failed to preempt %s: %v\n", d.conf.IP, err)
+		return
+	}
+
+	if d.hoster.Status() {
+		d.transition(RolePrimary)
+	}
+}
+
+// checkSplitBrain compares the local VIP with the routes ownership and the
+// peers reports, and runs the configured corrective actions on disagreement.
+// The preempt action only runs when we should claim the IP anyway (with all
+// the preempt gates), and checkSplitBrain returns true when it did.
+func (d *Daemon) checkSplitBrain(owner bool, role string, claim bool) bool {
+	var peers []string
+	if r, ok := d.elector.(election.Reporter); ok {
+		r.Report(owner)
+		peers = r.Owners()
+	}
+
+	if d.detector == nil {
+		return false
+	}
+
+	problems, err := d.detector.Check(owner, peers)
+	if err != nil {
+		d.fail("Failed to check for split-brain: %v\n", err)
+		return false
+	}
+
+	for _, problem := range problems {
+		d.log.Warnf("Split-brain: %s\n", problem)
+	}
+
+	if len(problems) == 0 {
+		return false
+	}
+
+	preempted := false
+	for _, action := range d.conf.SplitBrainActions {
+		var err error
+
+		switch action {
+		case splitbrain.ActionDropVIP:
+			if !owner {
+				err = d.detector.DropVIP()
+			}
+		case splitbrain.ActionPreempt:
+			if claim && !preempted {
+				d.preempt(role)
+				preempted = true
+			}
+		case splitbrain.ActionAlert:
+			err = d.notifier.Alert(strings.Join(problems, "; "))
+		}
+
+		if err != nil {
+			d.fail("Split-brain %s action failed: %v\n", action, err)
+		}
+	}
+
+	return preempted
+}
+
+// shouldClaim applies the election, startup and nopreempt policies to the desired role
+func (d *Daemon) shouldClaim(role string, first bool) bool {
+	// with an election, only the leader claims the IP (even unowned)
+	if d.elector != nil && role != RolePrimary {
+		return false
+	}
+
+	// without election, nopreempt primaries only claim unowned IPs
+	unownedOnly := d.elector == nil && d.conf.NoPreempt && role == RolePrimary
+
+	if first {
+		switch {
+		case d.conf.Startup == StartupClaimUnowned:
+			unownedOnly = true
+		case d.elector == nil && role == RolePrimary:
+			// a primary still claims an IP nobody owns
+			unownedOnly = true
+		default:
+			d.log.Infof("Starting as standby, not preempting %s before next check\n", d.conf.IP)
+			return false
+		}
+	} else if role != RolePrimary {
+		return false
+	}
+
+	if !unownedOnly {
+		return true
+	}
+
+	owner, err := d.hoster.Owner()
+	if err != nil {
+		d.fail("Failed to get %s owner: %v\n", d.conf.IP, err)
+		return false
+	}
+
+	if owner != "" {
+		d.log.Infof("Not preempting %s, owned by %s\n", d.conf.IP, owner)
+		return false
+	}
+
+	return true
+}
+
+// noticed records a termination notice, or its withdrawal (empty reason)
+func (d *Daemon) noticed(reason string) {
+	if reason != "" {
+		d.log.Infof("Received a termination notice: %s\n", reason)
+	} else if d.notice != "" {
+		d.log.Infof("Termination notice withdrawn: %s\n", d.notice)
+	}
+
+	d.notice = reason
+}
+
+// leave withdraws from the election until the notice is withdrawn, and gives
+// the IP away (to the handover instance, if any) as we're about to be terminated
+func (d *Daemon) leave() {
+	if d.elector != nil {
+		d.elector.SetEligible(false)
+	}
+
+	if d.hoster.Status() && !d.handOver(d.handover, d.conf.HandoverTo) {
+		d.release("Instance is about to be terminated")
+	}
+
+	d.transition(StateLeaving)
+}
+
+// giveAway drains the connections, then releases the IP or hands it over to
+// a standby, as per the stop policy; then waits until the change is visible
+// (or the stop timeout expires)
+func (d *Daemon) giveAway() {
+	if d.conf.OnStop != StopRelease && d.conf.OnStop != StopHandover {
+		return
+	}
+
+	if !d.hoster.Status() {
+		return
+	}
+
+	if err := d.pinned(""); err != nil {
+		d.log.Infof("Not giving %s away: %v\n", d.conf.IP, err)
+		return
+	}
+
+	d.drainer.Drain()
+
+	if d.conf.OnStop == StopHandover {
+		target, name := d.standby()
+		if d.handOver(target, name) {
+			d.await(fmt.Sprintf("%s to route to %s", d.conf.IP, name), target.Status)
+			return
+		}
+	}
+
+	if !d.release("Stopping") {
+		return
+	}
+
+	d.await(fmt.Sprintf("routes to %s to be deleted", d.conf.IP), func() bool {
+		return !d.hoster.Status()
+	})
+}
+
+// standby returns a hoster targeting the handover instance: the configured
+// one, or the best standby known by the elector (nil when there's none)
+func (d *Daemon) standby() (hoster.Hoster, string) {
+	if d.handover != nil {
+		return d.handover, d.conf.HandoverTo
+	}
+
+	if dis, ok := d.elector.(election.Discoverer); ok {
+		if name := dis.Standby(); name != "" {
+			target, err := hoster.ForInstance(d.hoster, d.conf, name, d.log)
+			if err != nil {
+				d.log.Errorf("Can't hand %s over to %s: %v\n", d.conf.IP, name, err)
+				return nil, ""
+			}
+			return target, name
+		}
+	}
+
+	d.log.Infof("No standby to hand %s over to\n", d.conf.IP)
+
+	return nil, ""
+}
+
+// handOver routes the IP to another instance, and returns true on success
+func (d *Daemon) handOver(target hoster.Hoster, name string) bool {
+	if target == nil {
+		return false
+	}
+
+	d.log.Infof("Handing %s over to %s\n", d.conf.IP, name)
+
+	if err := target.Preempt(); err != nil {
+		d.log.Errorf("Failed to hand %s over to %s: %v\n", d.conf.IP, name, err)
+		return false
+	}
+
+	return true
+}
+
+// await polls done until it returns true, or the stop timeout expires
+func (d *Daemon) await(what string, done func() bool) {
+	if d.conf.DryRun {
+		return
+	}
+
+	timeout := d.conf.StopTimeout
+	if timeout <= 0 {
+		timeout = defaultStopTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	d.log.Infof("Waiting for %s\n", what)
+
+	for !done() {
+		if time.Now().After(deadline) {
+			d.log.Warnf("Timed out waiting for %s\n", what)
+			return
+		}
+		time.Sleep(stopPollInterval)
+	}
+}
+
+// lostElection returns true when we were elected on previous loop, but aren't anymore
+func (d *Daemon) lostElection(role string) bool {
+	if d.elector == nil {
+		return false
+	}
+
+	lost := d.elected && role != RolePrimary
+	d.elected = role == RolePrimary
+
+	return lost
+}
+
+// release removes the routes to the instance (unless the IP is pinned),
+// and returns true on success
+func (d *Daemon) release(reason string) bool {
+	if err := d.pinned(""); err != nil {
+		d.log.Infof("%s, but not releasing %s: %v\n", reason, d.conf.IP, err)
+		return false
+	}
+
+	d.log.Infof("%s, releasing routes to %s\n", reason, d.conf.IP)
+
+	if err := d.hoster.Destroy(); err != nil {
+		d.fail("Failed to release %s: %v\n", d.conf.IP, err)
+		return false
+	}
+
+	return true
+}
+
+// pinned returns the maintenance pin error forbidding instance (any instance
+// when empty) to carry the IP, if any (and unless --force was given)
+func (d *Daemon) pinned(instance string) *pin.Error {
+	err := pin.Verify(d.hoster, instance, d.conf.Force)
+	if perr, ok := err.(*pin.Error); ok {
+		return perr
+	}
+
+	if err != nil {
+		d.fail("Failed to get %s pin: %v\n", d.conf.IP, err)
+	}
+
+	return nil
+}
+
+// fail logs an error, and marks the current reconciliation as failed
+func (d *Daemon) fail(format string, v ...interface{}) {
+	d.log.Errorf(format, v...)
+	d.ok = false
+}
+
+// transition records and reports state changes, running the state's hook
+func (d *Daemon) transition(state string) {
+	if state == d.state {
+		return
+	}
+
+	if d.state == "" {
+		d.log.Infof("Initial state is %s\n", state)
+	} else {
+		d.log.Infof("Transition from %s to %s\n", d.state, state)
+	}
+
+	previous := d.state
+	d.state = state
+
+	log.SetField(d.log, "CFI_ROLE", state)
+	d.sdNotify(fmt.Sprintf("STATUS=%s for %s", state, d.conf.IP))
+
+	if previous != "" {
+		d.damper.Transition(time.Now())
+	}
+
+	if err := d.notifier.Run(state, previous); err != nil {
+		d.log.Errorf("Failed to run %s hook: %v\n", state, err)
+	}
+}
@@ -0,0 +1,106 @@
+package daemon
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/hoster"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+	"github.com/bpineau/cloud-floating-ip/pkg/log/console"
+)
+
+type fakeElector struct {
+	leader bool
+}
+
+func (e *fakeElector) Init(conf *config.CfiConfig, h hoster.Hoster, logger log.Logger) error {
+	return nil
+}
+
+func (e *fakeElector) Run(ctx context.Context, changed chan<- bool) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (e *fakeElector) Leader() bool { return e.leader }
+
+func (e *fakeElector) SetEligible(eligible bool) {}
+
+// ownerHoster sees the IP owned by owner
+type ownerHoster struct {
+	hoster.Hoster
+	owner string
+}
+
+func (h *ownerHoster) Owner() (string, error) { return h.owner, nil }
+
+func TestShouldClaim(t *testing.T) {
+	tests := []struct {
+		name    string
+		elector bool
+		startup string
+		role    string
+		first   bool
+		owner   string
+		want    bool
+	}{
+		{"primary at startup", false, StartupStandby, RolePrimary, true, "i-2", false},
+		{"unowned primary at startup", false, StartupStandby, RolePrimary, true, "", true},
+		{"primary", false, StartupStandby, RolePrimary, false, "i-2", true},
+		{"standby at startup", false, StartupStandby, RoleStandby, true, "", false},
+		{"standby", false, StartupStandby, RoleStandby, false, "", false},
+		{"claim-unowned standby at startup", false, StartupClaimUnowned, RoleStandby, true, "", true},
+		{"elected", true, StartupStandby, RolePrimary, false, "i-2", true},
+		{"elected at startup", true, StartupStandby, RolePrimary, true, "", false},
+		{"not elected", true, StartupStandby, RoleStandby, false, "", false},
+		{"claim-unowned before being elected", true, StartupClaimUnowned, RoleStandby, true, "", false},
+	}
+
+	for _, tt := range tests {
+		d := &Daemon{
+			conf:   &config.CfiConfig{IP: "10.200.0.1", Startup: tt.startup},
+			log:    &console.Logger{Quiet: true},
+			hoster: &ownerHoster{owner: tt.owner},
+		}
+		if tt.elector {
+			d.elector = &fakeElector{leader: tt.role == RolePrimary}
+		}
+
+		if got := d.shouldClaim(tt.role, tt.first); got != tt.want {
+			t.Errorf("%s: shouldClaim() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// statusHoster doesn't own the IP
+type statusHoster struct {
+	hoster.Hoster
+}
+
+func (h *statusHoster) Status() bool { return false }
+
+func TestNotice(t *testing.T) {
+	conf := &config.CfiConfig{IP: "10.200.0.1", Role: RoleStandby}
+	d, err := New(conf, &statusHoster{}, &console.Logger{Quiet: true})
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+
+	tests := []struct {
+		reason string
+		state  string
+	}{
+		{"", RoleStandby},
+		{"instance/maintenance-event: TERMINATE_ON_HOST_MAINTENANCE", StateLeaving},
+		{"", RoleStandby},
+	}
+
+	for _, tt := range tests {
+		d.noticed(tt.reason)
+		d.reconcile()
+		if d.state != tt.state {
+			t.Errorf("after notice '%s': state = %s, want %s", tt.reason, d.state, tt.state)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package daemon
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/drain"
+	"github.com/bpineau/cloud-floating-ip/pkg/election"
+	"github.com/bpineau/cloud-floating-ip/pkg/health"
+	"github.com/bpineau/cloud-floating-ip/pkg/hoster"
+	"github.com/bpineau/cloud-floating-ip/pkg/notice"
+	"github.com/bpineau/cloud-floating-ip/pkg/notify"
+	"github.com/bpineau/cloud-floating-ip/pkg/quorum"
+	"github.com/bpineau/cloud-floating-ip/pkg/splitbrain"
+)
+
+// reload re-reads the configuration, and applies it when valid. The routes
+// to an unchanged IP are left untouched, and the election (or health checks)
+// only restart when their settings changed. Returns true on success.
+func (d *Daemon) reload() bool {
+	if d.conf.Reload == nil {
+		d.log.Warnf("Configuration reload isn't supported here\n")
+		return false
+	}
+
+	conf, err := d.conf.Reload()
+	if err != nil {
+		d.log.Errorf("Rejecting the new configuration: %v\n", err)
+		return false
+	}
+
+	next, err := d.prepare(conf)
+	if err != nil {
+		d.log.Errorf("Rejecting the new configuration: %v\n", err)
+		return false
+	}
+
+	d.apply(next)
+
+	d.log.Infof("Configuration reloaded\n")
+
+	return true
+}
+
+// prepare returns a daemon built from conf, reusing our components whose
+// settings didn't change. Running components are left untouched.
+func (d *Daemon) prepare(conf *config.CfiConfig) (*Daemon, error) {
+	if err := election.ResolveInstance(conf); err != nil {
+		return nil, fmt.Errorf("failed to resolve the target instance: %v", err)
+	}
+
+	kind, err := hoster.GuessHoster(conf.Hoster)
+	if err != nil {
+		return nil, err
+	}
+
+	next := &Daemon{
+		conf:    conf,
+		log:     d.log,
+		health:  d.health,
+		elector: d.elector,
+		watcher: d.watcher,
+		damper:  d.damper,
+	}
+
+	next.hoster, err = hoster.New(kind, conf, d.log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize %s hoster: %v", hoster.Name(kind), err)
+	}
+
+	// compared once initialized, as hosters fill conf (eg. with the zone)
+	if hoster.Name(next.hoster) == hoster.Name(d.hoster) &&
+		reflect.DeepEqual(hosterSettings(conf), hosterSettings(d.conf)) {
+		next.hoster = d.hoster
+	}
+
+	next.notifier = notify.New(conf, hoster.Name(next.hoster), d.log)
+	next.drainer = drain.New(conf, next.notifier, d.log)
+
+	if !reflect.DeepEqual(healthSettings(conf), healthSettings(d.conf)) {
+		if next.health, err = health.NewChecker(conf, d.log); err != nil {
+			return nil, err
+		}
+	}
+
+	// electors act through the hoster they were initialized with
+	if next.hoster != d.hoster || !reflect.DeepEqual(electionSettings(conf), electionSettings(d.conf)) {
+		next.elector = nil
+
+		if conf.Election != "" {
+			if next.elector, err = election.GetElector(conf.Election); err != nil {
+				return nil, err
+			}
+
+			if err = next.elector.Init(conf, next.hoster, d.log); err != nil {
+				return nil, fmt.Errorf("failed to initialize %s election: %v", conf.Election, err)
+			}
+		}
+	}
+
+	if !conf.WatchPreemption {
+		next.watcher = nil
+	} else if d.watcher == nil {
+		if next.watcher, err = notice.GuessWatcher(conf.Hoster); err != nil {
+			return nil, err
+		}
+
+		if err = next.watcher.Init(conf, d.log); err != nil {
+			return nil, fmt.Errorf("failed to watch termination notices: %v", err)
+		}
+	}
+
+	if conf.DetectSplitBrain {
+		if next.detector, err = splitbrain.New(conf, d.log); err != nil {
+			return nil, err
+		}
+	}
+
+	if next.quorum, err = quorum.New(conf, next.hoster, d.log); err != nil {
+		return nil, err
+	}
+
+	if conf.WitnessListen != "" {
+		if _, err = quorum.NewServer(conf, d.log, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.HandoverTo != "" {
+		next.handover, err = hoster.ForInstance(next.hoster, conf, conf.HandoverTo, d.log)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare the handover to %s: %v", conf.HandoverTo, err)
+		}
+	}
+
+	return next, nil
+}
+
+// apply switches to the components prepared by prepare, (re)starting the
+// election and the notices watcher when they changed
+func (d *Daemon) apply(next *Daemon) {
+	moved := next.conf.IP != d.conf.IP
+
+	if moved {
+		// we won't maintain the previous IP anymore
+		d.log.Infof("Switching from %s to %s\n", d.conf.IP, next.conf.IP)
+		d.giveAway()
+	}
+
+	if next.elector != d.elector {
+		if err := d.resign(); err != nil {
+			d.log.Errorf("Previous %s election ended with: %v\n", d.conf.Election, err)
+		}
+		// a new election isn't a lost election
+		d.elected = false
+	}
+
+	if next.watcher != d.watcher {
+		d.unwatch()
+	}
+
+	// the witness server judges with our current elector and hoster
+	d.unwitness()
+
+	next.damper.Update(next.conf)
+
+	restart := next.elector != d.elector && next.elector != nil
+	rewatch := next.watcher != d.watcher && next.watcher != nil
+
+	d.conf = next.conf
+	d.hoster = next.hoster
+	d.health = next.health
+	d.elector = next.elector
+	d.watcher = next.watcher
+	d.handover = next.handover
+	d.notifier = next.notifier
+	d.drainer = next.drainer
+	d.detector = next.detector
+	d.quorum = next.quorum
+
+	if moved {
+		d.state = ""
+	}
+
+	if restart {
+		d.campaign()
+	}
+
+	if rewatch {
+		d.watch()
+	}
+
+	if err := d.witness(); err != nil {
+		d.log.Errorf("Failed to answer witness requests: %v\n", err)
+	}
+}
+
+// hosterSettings returns the settings requiring a new hoster when changed
+func hosterSettings(c *config.CfiConfig) []interface{} {
+	return []interface{}{
+		c.Hoster, c.IP, c.Instance, c.Project, c.Zone, c.Region, c.Iface, c.Subnet, c.TargetIP,
+		c.RouteTables, c.NoMain, c.Fence, c.FenceTimeout, c.DryRun, c.Force, c.DestroyAll,
+		c.ExpectOwner, c.Election,
+	}
+}
+
+// healthSettings returns the settings requiring new health checks when changed
+func healthSettings(c *config.CfiConfig) []interface{} {
+	return []interface{}{c.HealthChecks, c.HealthRise, c.HealthFall}
+}
+
+// electionSettings returns the settings requiring a new election when changed
+func electionSettings(c *config.CfiConfig) []interface{} {
+	return []interface{}{
+		c.Election, c.Hoster, c.IP, c.Instance, c.Iface, c.Subnet, c.RouteTables, c.Priority, c.NoPreempt, c.Home, c.FailbackDelay,
+		c.AdvertInterval, c.Peers, c.PeerListen, c.AuthKey, c.LeaseDuration, c.LockKey,
+		c.EtcdEndpoints, c.ConsulAddress, c.KubeConfig, c.KubeNamespace, c.KubeLease, c.KubeNode,
+	}
+}
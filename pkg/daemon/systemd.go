@@ -0,0 +1,32 @@
+package daemon
+
+import (
+	"time"
+
+	sd "github.com/coreos/go-systemd/daemon"
+)
+
+// sdNotify sends a state (eg. READY=1) to systemd, when we run as a
+// Type=notify service (that is, when NOTIFY_SOCKET is set)
+func (d *Daemon) sdNotify(state string) {
+	if _, err := sd.SdNotify(false, state); err != nil {
+		d.log.Errorf("Failed to notify systemd: %v\n", err)
+	}
+}
+
+// watchdog returns a channel ticking twice per systemd's watchdog period
+// (never ticking when the watchdog isn't enabled), and a stop function
+func (d *Daemon) watchdog() (<-chan time.Time, func()) {
+	period, err := sd.SdWatchdogEnabled(false)
+	if err != nil {
+		d.log.Errorf("Invalid systemd watchdog settings: %v\n", err)
+	}
+
+	if period <= 0 {
+		return nil, func() {}
+	}
+
+	ticker := time.NewTicker(period / 2)
+
+	return ticker.C, ticker.Stop
+}
@@ -0,0 +1,99 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/inventory"
+	"github.com/bpineau/cloud-floating-ip/pkg/lease"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+	"github.com/bpineau/cloud-floating-ip/pkg/log/console"
+	"github.com/bpineau/cloud-floating-ip/pkg/ownership"
+	"github.com/bpineau/cloud-floating-ip/pkg/pin"
+	"github.com/bpineau/cloud-floating-ip/pkg/plan"
+)
+
+// fakeHoster always owns the routes
+type fakeHoster struct{}
+
+func (h *fakeHoster) Init(conf *config.CfiConfig, logger log.Logger) error { return nil }
+func (h *fakeHoster) OnThisHoster() bool                                   { return false }
+func (h *fakeHoster) Preempt() error                                       { return nil }
+func (h *fakeHoster) Fence() error                                         { return nil }
+func (h *fakeHoster) Status() bool                                         { return true }
+func (h *fakeHoster) Owner() (string, error)                               { return "i-1", nil }
+func (h *fakeHoster) Destroy() error                                       { return nil }
+func (h *fakeHoster) GetLease() (*lease.Lease, error)                      { return nil, nil }
+func (h *fakeHoster) SwapLease(prev, next *lease.Lease) error              { return nil }
+func (h *fakeHoster) GetPin() (*pin.Pin, error)                            { return nil, nil }
+func (h *fakeHoster) SetPin(p *pin.Pin) error                              { return nil }
+func (h *fakeHoster) InstanceHealthy(instance string) (bool, error)        { return true, nil }
+func (h *fakeHoster) LocalInstance() (string, error)                       { return "i-1", nil }
+func (h *fakeHoster) List() ([]*inventory.Entry, error)                    { return nil, nil }
+func (h *fakeHoster) Ownership() (*ownership.Ownership, error)             { return &ownership.Ownership{}, nil }
+func (h *fakeHoster) Repair() error                                        { return nil }
+func (h *fakeHoster) Plan(destroy bool) (*plan.Plan, error)                { return nil, nil }
+func (h *fakeHoster) Apply(p *plan.Plan) error                             { return nil }
+
+func TestSystemdNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	os.Setenv("WATCHDOG_USEC", "100000")
+	os.Setenv("WATCHDOG_PID", fmt.Sprintf("%d", os.Getpid()))
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	conf := &config.CfiConfig{IP: "10.200.0.1", Instance: "i-1", Role: RolePrimary, Interval: time.Minute}
+	d, err := New(conf, &fakeHoster{}, &console.Logger{Quiet: true})
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- d.Run() }()
+
+	for _, want := range []string{"READY=1", "STATUS=primary for 10.200.0.1", "WATCHDOG=1"} {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+		buf := make([]byte, 256)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("no %s notification: %v", want, err)
+		}
+
+		if got := string(buf[:n]); got != want {
+			t.Errorf("got notification '%s', want '%s'", got, want)
+		}
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run() = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Run() didn't stop on SIGTERM")
+	}
+}
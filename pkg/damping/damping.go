@@ -0,0 +1,102 @@
+// Package damping limits the rate of failovers, so instances flapping between
+// two states don't keep rewriting the routes (each GCE failover deletes then
+// inserts a route, causing a short outage).
+package damping
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+)
+
+const defaultWindow = time.Hour
+
+// Damper decides whether a failover may happen now
+type Damper struct {
+	hold    time.Duration
+	max     int
+	window  time.Duration
+	backoff time.Duration
+
+	transition time.Time
+	failovers  []time.Time
+}
+
+// New returns a damper enforcing conf's failover limits (none by default)
+func New(conf *config.CfiConfig) *Damper {
+	window := conf.FailoverWindow
+	if window <= 0 {
+		window = defaultWindow
+	}
+
+	return &Damper{
+		hold:    conf.MinHold,
+		max:     conf.MaxFailovers,
+		window:  window,
+		backoff: conf.FailoverBackoff,
+	}
+}
+
+// Update applies conf's failover limits, keeping the recorded transitions and failovers
+func (d *Damper) Update(conf *config.CfiConfig) {
+	n := New(conf)
+	d.hold, d.max, d.window, d.backoff = n.hold, n.max, n.window, n.backoff
+}
+
+// Transition records a state transition, starting the minimum hold time
+func (d *Damper) Transition(now time.Time) {
+	d.transition = now
+}
+
+// Failover records a failover (a preempt attempt)
+func (d *Damper) Failover(now time.Time) {
+	d.failovers = append(d.failovers, now)
+}
+
+// Allow returns an error describing why a failover should be damped, if it should
+func (d *Damper) Allow(now time.Time) error {
+	if elapsed := now.Sub(d.transition); d.hold > 0 && elapsed < d.hold {
+		return fmt.Errorf("last transition was %s ago, holding for %s", elapsed.Round(time.Second), d.hold)
+	}
+
+	d.prune(now)
+	count := len(d.failovers)
+
+	if d.max > 0 && count >= d.max {
+		return fmt.Errorf("%d failovers in the last %s", count, d.window)
+	}
+
+	if d.backoff <= 0 || count == 0 {
+		return nil
+	}
+
+	if delay := d.delay(count); now.Sub(d.failovers[count-1]) < delay {
+		return fmt.Errorf("backing off for %s after %d failovers in the last %s", delay, count, d.window)
+	}
+
+	return nil
+}
+
+// prune forgets failovers older than the window
+func (d *Damper) prune(now time.Time) {
+	i := 0
+	for i < len(d.failovers) && now.Sub(d.failovers[i]) >= d.window {
+		i++
+	}
+	d.failovers = d.failovers[i:]
+}
+
+// delay is the backoff doubling with each recent failover, up to the window
+func (d *Damper) delay(count int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < count && delay < d.window; i++ {
+		delay *= 2
+	}
+
+	if delay > d.window {
+		return d.window
+	}
+
+	return delay
+}
@@ -0,0 +1,82 @@
+package damping
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+)
+
+func TestHold(t *testing.T) {
+	now := time.Now()
+	d := New(&config.CfiConfig{MinHold: time.Minute})
+
+	d.Transition(now)
+	if err := d.Allow(now.Add(30 * time.Second)); err == nil {
+		t.Error("Allow() during the hold time")
+	}
+	if err := d.Allow(now.Add(time.Minute)); err != nil {
+		t.Errorf("Allow() = %v after the hold time", err)
+	}
+}
+
+func TestMaxFailovers(t *testing.T) {
+	now := time.Now()
+	d := New(&config.CfiConfig{MaxFailovers: 2, FailoverWindow: 10 * time.Minute})
+
+	d.Failover(now)
+	d.Failover(now.Add(time.Minute))
+	if err := d.Allow(now.Add(2 * time.Minute)); err == nil {
+		t.Error("Allow() after the maximum failovers")
+	}
+
+	// the first failover left the window
+	if err := d.Allow(now.Add(10 * time.Minute)); err != nil {
+		t.Errorf("Allow() = %v once a failover left the window", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	now := time.Now()
+	d := New(&config.CfiConfig{FailoverBackoff: time.Minute, FailoverWindow: 5 * time.Minute})
+
+	if err := d.Allow(now); err != nil {
+		t.Errorf("Allow() = %v without failovers", err)
+	}
+
+	tests := []struct {
+		failovers int
+		delay     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 5 * time.Minute}, // capped to the window
+	}
+
+	for _, tt := range tests {
+		if got := d.delay(tt.failovers); got != tt.delay {
+			t.Errorf("delay(%d) = %s, want %s", tt.failovers, got, tt.delay)
+		}
+	}
+
+	d.Failover(now)
+	d.Failover(now.Add(time.Second))
+	if err := d.Allow(now.Add(time.Minute)); err == nil {
+		t.Error("Allow() while backing off")
+	}
+	if err := d.Allow(now.Add(2*time.Minute + time.Second)); err != nil {
+		t.Errorf("Allow() = %v after the backoff", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	now := time.Now()
+	d := New(&config.CfiConfig{})
+	d.Failover(now)
+
+	d.Update(&config.CfiConfig{MaxFailovers: 1})
+	if err := d.Allow(now.Add(time.Second)); err == nil {
+		t.Error("Update() forgot the recorded failovers")
+	}
+}
@@ -0,0 +1,187 @@
+// Package drain lets established connections to the floating IP complete
+// before we voluntarily move it away: a drain command stops accepting new
+// connections, then we wait until the connections count falls to a threshold.
+package drain
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+	"github.com/bpineau/cloud-floating-ip/pkg/notify"
+	"github.com/bpineau/cloud-floating-ip/pkg/shell"
+)
+
+const (
+	conntrackFile = "/proc/net/nf_conntrack"
+	pollInterval  = 2 * time.Second
+	probeTimeout  = 10 * time.Second
+)
+
+// Drainer runs the drain phase preceding voluntary IP moves
+type Drainer struct {
+	conf     *config.CfiConfig
+	log      log.Logger
+	notifier *notify.Notifier
+}
+
+// New returns a drainer running notifier's drain command, and counting the
+// connections with conf's drain probe (or conntrack)
+func New(conf *config.CfiConfig, notifier *notify.Notifier, logger log.Logger) *Drainer {
+	return &Drainer{
+		conf:     conf,
+		log:      logger,
+		notifier: notifier,
+	}
+}
+
+// Enabled returns true when a drain timeout is configured
+func (d *Drainer) Enabled() bool {
+	return d.conf.DrainTimeout > 0
+}
+
+// Local returns true when the IP is configured on this host, which then
+// serves (and can drain) the connections to it
+func (d *Drainer) Local() bool {
+	ip := net.ParseIP(d.conf.IP)
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil || ip == nil {
+		return false
+	}
+
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Drain runs the drain command, then waits until at most DrainThreshold
+// connections to the IP are left, or DrainTimeout expires. Failures are
+// logged, and never prevent the IP move.
+func (d *Drainer) Drain() {
+	if !d.Enabled() {
+		return
+	}
+
+	d.log.Infof("Draining %s before moving it away\n", d.conf.IP)
+
+	if err := d.notifier.Drain(); err != nil {
+		d.log.Errorf("Failed to run drain command: %v\n", err)
+	}
+
+	if d.conf.DryRun {
+		return
+	}
+
+	deadline := time.Now().Add(d.conf.DrainTimeout)
+
+	d.log.Infof("Waiting for at most %d connections to %s (up to %s)\n",
+		d.conf.DrainThreshold, d.conf.IP, d.conf.DrainTimeout)
+
+	last := -1
+	for {
+		count, err := d.count()
+		if err != nil {
+			d.log.Errorf("Failed to count connections to %s, not waiting: %v\n", d.conf.IP, err)
+			return
+		}
+
+		if count <= d.conf.DrainThreshold {
+			d.log.Infof("Drained %s (%d connections left)\n", d.conf.IP, count)
+			return
+		}
+
+		if time.Now().After(deadline) {
+			d.log.Warnf("Timed out draining %s (%d connections left)\n", d.conf.IP, count)
+			return
+		}
+
+		if count != last {
+			d.log.Infof("%d connections to %s left\n", count, d.conf.IP)
+			last = count
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
+
+// count returns the number of connections to the IP
+func (d *Drainer) count() (int, error) {
+	if d.conf.DrainProbe == "" {
+		return countConntrack(d.conf.IP)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	env := []string{"CFI_IP=" + d.conf.IP, "CFI_INSTANCE=" + d.conf.Instance}
+
+	out, err := shell.Run(ctx, d.conf.DrainProbe, env, os.Stderr)
+	if err != nil {
+		return 0, fmt.Errorf("drain probe failed: %v", err)
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		return 0, fmt.Errorf("drain probe didn't print a connections count: %q", out)
+	}
+
+	return count, nil
+}
+
+// countConntrack returns the number of live conntrack entries whose original
+// destination is ip
+func countConntrack(ip string) (int, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return 0, fmt.Errorf("invalid IP: '%s'", ip)
+	}
+
+	f, err := os.Open(conntrackFile)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if closed(fields) {
+			continue
+		}
+
+		for _, field := range fields {
+			// the first dst is the original direction's destination
+			if strings.HasPrefix(field, "dst=") {
+				if addr.Equal(net.ParseIP(strings.TrimPrefix(field, "dst="))) {
+					count++
+				}
+				break
+			}
+		}
+	}
+
+	return count, scanner.Err()
+}
+
+// closed returns true for conntrack entries of terminated TCP connections,
+// eg. "ipv4 2 tcp 6 117 TIME_WAIT src=..."
+func closed(fields []string) bool {
+	if len(fields) < 6 || fields[2] != "tcp" {
+		return false
+	}
+
+	return fields[5] == "TIME_WAIT" || fields[5] == "CLOSE"
+}
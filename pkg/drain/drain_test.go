@@ -0,0 +1,66 @@
+package drain
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/log/console"
+)
+
+func TestLocal(t *testing.T) {
+	tests := []struct {
+		ip    string
+		local bool
+	}{
+		{"127.0.0.1", true},
+		{"192.0.2.1", false},
+		{"not an IP", false},
+	}
+
+	for _, tt := range tests {
+		d := New(&config.CfiConfig{IP: tt.ip}, nil, &console.Logger{Quiet: true})
+		if got := d.Local(); got != tt.local {
+			t.Errorf("Local() = %v for %s", got, tt.ip)
+		}
+	}
+}
+
+func TestClosed(t *testing.T) {
+	tests := []struct {
+		entry  string
+		closed bool
+	}{
+		{"ipv4 2 tcp 6 117 TIME_WAIT src=10.0.0.2 dst=10.200.0.1 sport=4242 dport=80", true},
+		{"ipv4 2 tcp 6 431999 ESTABLISHED src=10.0.0.2 dst=10.200.0.1 sport=4242 dport=80", false},
+		{"ipv4 2 udp 17 29 src=10.0.0.2 dst=10.200.0.1 sport=4242 dport=53", false},
+	}
+
+	for _, tt := range tests {
+		if got := closed(strings.Fields(tt.entry)); got != tt.closed {
+			t.Errorf("closed(%s) = %v", tt.entry, got)
+		}
+	}
+}
+
+func TestCountProbe(t *testing.T) {
+	conf := &config.CfiConfig{IP: "10.200.0.1"}
+	d := New(conf, nil, &console.Logger{Quiet: true})
+
+	// a background process holding the output open doesn't stall the probe
+	conf.DrainProbe = "exec 2>&-; sleep 10 & echo 3"
+
+	start := time.Now()
+	if count, err := d.count(); err != nil || count != 3 {
+		t.Errorf("count() = %d, %v", count, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("count() took %s", elapsed)
+	}
+
+	conf.DrainProbe = "echo many"
+	if _, err := d.count(); err == nil {
+		t.Error("count() accepted a probe printing no count")
+	}
+}
@@ -0,0 +1,214 @@
+// Package cloud implements a leader election using a lease stored by the
+// hoster itself (security groups on AWS, a route description on GCE), so we
+// don't need any coordination service beyond the cloud API.
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/hoster"
+	"github.com/bpineau/cloud-floating-ip/pkg/lease"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+)
+
+const defaultLeaseDuration = 30 * time.Second
+
+// Elector represents a cloud lease election backend
+type Elector struct {
+	conf   *config.CfiConfig
+	log    log.Logger
+	hoster hoster.Hoster
+	ttl    time.Duration
+
+	observed *lease.Lease
+
+	mu       sync.Mutex
+	held     *lease.Lease
+	eligible bool
+}
+
+// Init prepares the cloud lease elector for usage
+func (e *Elector) Init(conf *config.CfiConfig, h hoster.Hoster, logger log.Logger) error {
+	e.conf = conf
+	e.log = logger
+	e.hoster = h
+	e.eligible = true
+
+	e.ttl = conf.LeaseDuration
+	if e.ttl <= 0 {
+		e.ttl = defaultLeaseDuration
+	}
+
+	return nil
+}
+
+// Run acquires then renews the lease (every third of its duration), until ctx is cancelled
+func (e *Elector) Run(ctx context.Context, changed chan<- bool) error {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	leader := false
+
+	for {
+		e.campaign()
+
+		if e.Leader() != leader {
+			leader = !leader
+			select {
+			case changed <- leader:
+			default:
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			e.release()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Leader returns true while we hold an unexpired lease
+func (e *Elector) Leader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.held != nil && !e.held.Expired(time.Now())
+}
+
+// SetEligible withdraws (or restores) our candidacy; we release the lease on next renewal
+func (e *Elector) SetEligible(eligible bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.eligible = eligible
+}
+
+// Fence verifies that the lease we hold is still the current one
+func (e *Elector) Fence() error {
+	e.mu.Lock()
+	held := e.held
+	e.mu.Unlock()
+
+	if held == nil {
+		return fmt.Errorf("we don't hold the lease")
+	}
+
+	current, err := e.hoster.GetLease()
+	if err != nil {
+		return fmt.Errorf("failed to read the lease: %v", err)
+	}
+
+	if current == nil || current.Holder != held.Holder || current.Generation != held.Generation {
+		return fmt.Errorf("lease generation %d was superseded", held.Generation)
+	}
+
+	return nil
+}
+
+// campaign acquires, renews or releases the lease, depending on its current
+// state. Only called from Run, so only held's updates need to be locked.
+func (e *Elector) campaign() {
+	e.mu.Lock()
+	held, eligible := e.held, e.eligible
+	e.mu.Unlock()
+
+	now := time.Now()
+	me := e.conf.Instance
+
+	current, err := e.hoster.GetLease()
+	if err != nil {
+		e.log.Errorf("Failed to read the lease: %v\n", err)
+		return
+	}
+
+	if !eligible {
+		if current != nil && current.Holder == me {
+			e.swap(current, &lease.Lease{Holder: "", Expiry: now, Generation: current.Generation})
+		}
+		e.lose("instance isn't eligible")
+		return
+	}
+
+	if current == nil && e.observed != nil && !e.observed.Expired(now) && e.observed.Holder != me {
+		// the lease route may briefly vanish while its holder renews it (GCE)
+		return
+	}
+
+	if current != nil {
+		e.observed = current
+	}
+
+	if current != nil && current.Holder != me && !current.Expired(now) {
+		e.lose(fmt.Sprintf("lease is held by %s", current.Holder))
+		return
+	}
+
+	next := &lease.Lease{Holder: me, Expiry: now.Add(e.ttl), Generation: 1}
+	if current != nil {
+		next.Generation = current.Generation
+		if current.Holder != me || held == nil || held.Generation != current.Generation {
+			next.Generation++
+		}
+	}
+
+	if !e.swap(current, next) {
+		e.lose("lost a race for the lease")
+		return
+	}
+
+	if held == nil || held.Generation != next.Generation {
+		e.log.Infof("Acquired lease for %s (generation %d)\n", e.conf.IP, next.Generation)
+	}
+
+	e.mu.Lock()
+	e.held = next
+	e.mu.Unlock()
+	e.observed = next
+}
+
+func (e *Elector) swap(prev, next *lease.Lease) bool {
+	err := e.hoster.SwapLease(prev, next)
+	if err == nil {
+		return true
+	}
+
+	if err != lease.ErrConflict {
+		e.log.Errorf("Failed to write the lease: %v\n", err)
+	}
+
+	return false
+}
+
+// lose forgets the lease we held (if any)
+func (e *Elector) lose(reason string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.held == nil {
+		return
+	}
+
+	e.log.Infof("Lost lease for %s: %s\n", e.conf.IP, reason)
+	e.held = nil
+}
+
+// release expires the lease we hold, so another instance can take over at once
+func (e *Elector) release() {
+	e.mu.Lock()
+	held := e.held
+	e.held = nil
+	e.mu.Unlock()
+
+	if held == nil {
+		return
+	}
+
+	released := &lease.Lease{Holder: "", Expiry: time.Now(), Generation: held.Generation}
+	if e.swap(held, released) {
+		e.log.Infof("Released lease for %s\n", e.conf.IP)
+	}
+}
@@ -0,0 +1,235 @@
+// Package consul implements a leader election using a Consul session and a
+// KV lock. The lock key's LockIndex is our fencing token.
+package consul
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/hoster"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+)
+
+const (
+	defaultLeaseDuration = 15 * time.Second
+	defaultKeyPrefix     = "cloud-floating-ip/"
+)
+
+// Elector represents a Consul lock election backend
+type Elector struct {
+	conf   *config.CfiConfig
+	log    log.Logger
+	client *api.Client
+	key    string
+	ttl    time.Duration
+
+	mu        sync.Mutex
+	leader    bool
+	lockIndex uint64
+	eligible  bool
+	stop      chan struct{}
+	wake      chan struct{}
+}
+
+// Init prepares the Consul client (honoring the usual CONSUL_* environment variables)
+func (e *Elector) Init(conf *config.CfiConfig, h hoster.Hoster, logger log.Logger) error {
+	e.conf = conf
+	e.log = logger
+	e.eligible = true
+	e.wake = make(chan struct{}, 1)
+
+	e.key = conf.LockKey
+	if e.key == "" {
+		e.key = defaultKeyPrefix + conf.IP
+	}
+
+	e.ttl = conf.LeaseDuration
+	if e.ttl <= 0 {
+		e.ttl = defaultLeaseDuration
+	}
+
+	cfg := api.DefaultConfig()
+	if conf.ConsulAddress != "" {
+		cfg.Address = conf.ConsulAddress
+	}
+
+	var err error
+	e.client, err = api.NewClient(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create a consul client: %v", err)
+	}
+
+	return nil
+}
+
+// Run campaigns (while we're eligible) until ctx is cancelled. A lost
+// session (eg. invalidated by a failing consul agent) ends our leadership.
+func (e *Elector) Run(ctx context.Context, changed chan<- bool) error {
+	for {
+		if err := e.campaign(ctx, changed); err != nil && ctx.Err() == nil {
+			e.log.Errorf("consul election on %s failed: %v\n", e.key, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-e.wake:
+		case <-time.After(e.ttl / 3):
+		}
+	}
+}
+
+// campaign waits for the lock, then holds it until the session is lost,
+// ctx is cancelled, or we're not eligible anymore.
+func (e *Elector) campaign(ctx context.Context, changed chan<- bool) error {
+	stop, ok := e.campaignChannel(ctx)
+	if !ok {
+		return nil
+	}
+	defer e.stopCampaign()
+
+	lock, err := e.client.LockOpts(&api.LockOptions{
+		Key:         e.key,
+		Value:       []byte(e.conf.Instance),
+		SessionName: "cloud-floating-ip " + e.conf.Instance,
+		SessionTTL:  e.ttl.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create a lock: %v", err)
+	}
+
+	lost, err := lock.Lock(stop)
+	if err != nil {
+		return fmt.Errorf("failed to acquire the lock: %v", err)
+	}
+
+	if lost == nil {
+		// stopped while waiting for the lock
+		return nil
+	}
+
+	pair, _, err := e.client.KV().Get(e.key, nil)
+	if err != nil || pair == nil {
+		_ = lock.Unlock()
+		return fmt.Errorf("failed to read the lock index: %v", err)
+	}
+
+	e.setLeader(true, pair.LockIndex, changed)
+
+	select {
+	case <-stop:
+		err = lock.Unlock()
+	case <-lost:
+		err = fmt.Errorf("session lost")
+	}
+
+	e.setLeader(false, 0, changed)
+
+	return err
+}
+
+// campaignChannel returns a channel closed when ctx is cancelled, or when we're not eligible anymore
+func (e *Elector) campaignChannel(ctx context.Context) (chan struct{}, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.eligible {
+		return nil, false
+	}
+
+	stop := make(chan struct{})
+	e.stop = stop
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			e.stopCampaign()
+		case <-stop:
+		}
+	}()
+
+	return stop, true
+}
+
+func (e *Elector) stopCampaign() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.stop != nil {
+		close(e.stop)
+		e.stop = nil
+	}
+}
+
+func (e *Elector) setLeader(leader bool, index uint64, changed chan<- bool) {
+	e.mu.Lock()
+	e.leader = leader
+	e.lockIndex = index
+	e.mu.Unlock()
+
+	if leader {
+		e.log.Infof("Acquired lock %s (lock index %d)\n", e.key, index)
+	} else {
+		e.log.Infof("Released lock %s\n", e.key)
+	}
+
+	select {
+	case changed <- leader:
+	default:
+	}
+}
+
+// Leader returns true while we hold the lock
+func (e *Elector) Leader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// SetEligible withdraws (releasing the lock) or restores our candidacy
+func (e *Elector) SetEligible(eligible bool) {
+	e.mu.Lock()
+	if e.eligible == eligible {
+		e.mu.Unlock()
+		return
+	}
+	e.eligible = eligible
+	e.mu.Unlock()
+
+	if !eligible {
+		e.stopCampaign()
+		return
+	}
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Fence verifies that the lock is still held with the lock index we acquired
+func (e *Elector) Fence() error {
+	e.mu.Lock()
+	leader, index := e.leader, e.lockIndex
+	e.mu.Unlock()
+
+	if !leader {
+		return fmt.Errorf("we don't hold the lock %s", e.key)
+	}
+
+	pair, _, err := e.client.KV().Get(e.key, &api.QueryOptions{RequireConsistent: true})
+	if err != nil {
+		return fmt.Errorf("failed to read the lock %s: %v", e.key, err)
+	}
+
+	if pair == nil || pair.Session == "" || pair.LockIndex != index || string(pair.Value) != e.conf.Instance {
+		return fmt.Errorf("lock %s (lock index %d) was superseded", e.key, index)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+// Package election coordinates several instances, so only one of them
+// (the leader) carries the floating IP at a given time.
+package election
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/election/cloud"
+	"github.com/bpineau/cloud-floating-ip/pkg/election/consul"
+	"github.com/bpineau/cloud-floating-ip/pkg/election/etcd"
+	"github.com/bpineau/cloud-floating-ip/pkg/election/kube"
+	"github.com/bpineau/cloud-floating-ip/pkg/election/peer"
+	"github.com/bpineau/cloud-floating-ip/pkg/hoster"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+)
+
+// Elector represents a leader election backend
+type Elector interface {
+	// Init prepares the elector for usage, given an initialized hoster
+	Init(conf *config.CfiConfig, h hoster.Hoster, logger log.Logger) error
+
+	// Run campaigns until the context is cancelled, notifying leadership
+	// transitions on the changed channel (without blocking).
+	Run(ctx context.Context, changed chan<- bool) error
+
+	// Leader returns true when this instance won the election
+	Leader() bool
+
+	// SetEligible withdraws (or restores) this instance's candidacy
+	SetEligible(eligible bool)
+}
+
+// Fencer electors can verify, right before we modify routes, that we're
+// still the leader (eg. that our fencing token is still current).
+type Fencer interface {
+	Fence() error
+}
+
+// Reporter electors share each member's routes ownership (eg. in heartbeats),
+// so we can spot several instances believing they're primary.
+type Reporter interface {
+	// Report publishes whether the routes target this instance
+	Report(owner bool)
+
+	// Owners returns the live peers reporting they own the routes
+	Owners() []string
+}
+
+// Discoverer electors know the other candidates, so we can hand the IP over
+type Discoverer interface {
+	// Standby returns the best live and eligible peer (empty when none)
+	Standby() string
+}
+
+// Observer electors know which members are live masters, so the daemon can
+// serve as a witness for other instances about to take the IP over
+type Observer interface {
+	// Master returns whether the instance is a live master, and whether we know it
+	Master(instance string) (master bool, known bool)
+}
+
+// Resolver electors find the target instance by themselves (eg. from the
+// Kubernetes node's providerID), before the hoster is initialized.
+type Resolver interface {
+	ResolveInstance(conf *config.CfiConfig) error
+}
+
+var allElectors = map[string]func() Elector{
+	"peer":   func() Elector { return &peer.Elector{} },
+	"cloud":  func() Elector { return &cloud.Elector{} },
+	"kube":   func() Elector { return &kube.Elector{} },
+	"etcd":   func() Elector { return &etcd.Elector{} },
+	"consul": func() Elector { return &consul.Elector{} },
+}
+
+// GetElector returns a new (uninitialized) instance of the election backend
+// described by name, so we can prepare it while another one is running
+func GetElector(name string) (Elector, error) {
+	if newElector, ok := allElectors[name]; ok {
+		return newElector(), nil
+	}
+
+	return nil, errors.New("election backend not supported: " + name)
+}
+
+// ResolveInstance lets the configured election backend (if any) fill the
+// target instance settings, when it knows better than instance's metadata.
+func ResolveInstance(conf *config.CfiConfig) error {
+	if conf.Election == "" {
+		return nil
+	}
+
+	elector, err := GetElector(conf.Election)
+	if err != nil {
+		return err
+	}
+
+	if r, ok := elector.(Resolver); ok {
+		return r.ResolveInstance(conf)
+	}
+
+	return nil
+}
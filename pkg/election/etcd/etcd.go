@@ -0,0 +1,226 @@
+// Package etcd implements a leader election using an etcd lease backed
+// concurrency.Election. The leader key's creation revision is our fencing token.
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/hoster"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+)
+
+const (
+	defaultLeaseDuration = 15 * time.Second
+	defaultKeyPrefix     = "cloud-floating-ip/"
+	dialTimeout          = 5 * time.Second
+)
+
+// Elector represents an etcd election backend
+type Elector struct {
+	conf   *config.CfiConfig
+	log    log.Logger
+	client *clientv3.Client
+	key    string
+	ttl    time.Duration
+
+	mu       sync.Mutex
+	election *concurrency.Election
+	leader   bool
+	eligible bool
+	cancel   context.CancelFunc
+	wake     chan struct{}
+}
+
+// Init connects to the etcd cluster
+func (e *Elector) Init(conf *config.CfiConfig, h hoster.Hoster, logger log.Logger) error {
+	e.conf = conf
+	e.log = logger
+	e.eligible = true
+	e.wake = make(chan struct{}, 1)
+
+	if len(conf.EtcdEndpoints) == 0 {
+		return fmt.Errorf("etcd election requires etcd endpoints")
+	}
+
+	e.key = conf.LockKey
+	if e.key == "" {
+		e.key = defaultKeyPrefix + conf.IP
+	}
+
+	e.ttl = conf.LeaseDuration
+	if e.ttl <= 0 {
+		e.ttl = defaultLeaseDuration
+	}
+
+	var err error
+	e.client, err = clientv3.New(clientv3.Config{
+		Endpoints:   conf.EtcdEndpoints,
+		DialTimeout: dialTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect to etcd: %v", err)
+	}
+
+	return nil
+}
+
+// Run campaigns (while we're eligible) until ctx is cancelled. A lost
+// session (eg. etcd unreachable for longer than the lease) ends our leadership.
+func (e *Elector) Run(ctx context.Context, changed chan<- bool) error {
+	defer e.client.Close()
+
+	for {
+		if err := e.campaign(ctx, changed); err != nil && ctx.Err() == nil {
+			e.log.Errorf("etcd election on %s failed: %v\n", e.key, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-e.wake:
+		case <-time.After(e.ttl / 3):
+		}
+	}
+}
+
+// campaign waits to be elected, then holds the leadership until the session
+// is lost, ctx is cancelled, or we're not eligible anymore.
+func (e *Elector) campaign(ctx context.Context, changed chan<- bool) error {
+	campaign, ok := e.campaignContext(ctx)
+	if !ok {
+		return nil
+	}
+	defer e.stopCampaign()
+
+	// closing the session revokes its lease, deleting our key at once
+	session, err := concurrency.NewSession(e.client, concurrency.WithTTL(int(e.ttl.Seconds())))
+	if err != nil {
+		return fmt.Errorf("failed to create a session: %v", err)
+	}
+	defer session.Close()
+
+	election := concurrency.NewElection(session, e.key)
+	if err = election.Campaign(campaign, e.conf.Instance); err != nil {
+		if campaign.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	e.setLeader(election, changed)
+
+	select {
+	case <-campaign.Done():
+		resign, cancel := context.WithTimeout(context.Background(), dialTimeout)
+		defer cancel()
+		err = election.Resign(resign)
+	case <-session.Done():
+		err = fmt.Errorf("session lost")
+	}
+
+	e.setLeader(nil, changed)
+
+	return err
+}
+
+func (e *Elector) campaignContext(ctx context.Context) (context.Context, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.eligible {
+		return nil, false
+	}
+
+	campaign, cancel := context.WithCancel(ctx)
+	e.cancel = cancel
+
+	return campaign, true
+}
+
+func (e *Elector) stopCampaign() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.cancel != nil {
+		e.cancel()
+		e.cancel = nil
+	}
+}
+
+func (e *Elector) setLeader(election *concurrency.Election, changed chan<- bool) {
+	e.mu.Lock()
+	e.election = election
+	e.leader = election != nil
+	e.mu.Unlock()
+
+	if election != nil {
+		e.log.Infof("Elected on %s (revision %d)\n", e.key, election.Rev())
+	} else {
+		e.log.Infof("Not leader on %s anymore\n", e.key)
+	}
+
+	select {
+	case changed <- election != nil:
+	default:
+	}
+}
+
+// Leader returns true while we hold the election key
+func (e *Elector) Leader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// SetEligible withdraws (resigning) or restores our candidacy
+func (e *Elector) SetEligible(eligible bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.eligible == eligible {
+		return
+	}
+	e.eligible = eligible
+
+	if !eligible && e.cancel != nil {
+		e.cancel()
+		return
+	}
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Fence verifies that the current leader key is still the one we created
+func (e *Elector) Fence() error {
+	e.mu.Lock()
+	election := e.election
+	e.mu.Unlock()
+
+	if election == nil {
+		return fmt.Errorf("we're not leader on %s", e.key)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+
+	resp, err := election.Leader(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get the leader on %s: %v", e.key, err)
+	}
+
+	if len(resp.Kvs) == 0 || resp.Kvs[0].CreateRevision != election.Rev() {
+		return fmt.Errorf("leader revision %d on %s was superseded", election.Rev(), e.key)
+	}
+
+	return nil
+}
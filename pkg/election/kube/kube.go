@@ -0,0 +1,301 @@
+// Package kube implements a leader election using a coordination.k8s.io/v1
+// Lease, for instances running cloud-floating-ip as (eg. DaemonSet) pods.
+// The target instance is the pod's node, resolved from its providerID.
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/hoster"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+)
+
+const (
+	defaultLeaseDuration = 15 * time.Second
+	defaultNamespace     = "default"
+	leasePrefix          = "cloud-floating-ip-"
+)
+
+// Elector represents a Kubernetes Lease election backend
+type Elector struct {
+	// Client is the Kubernetes client (built from the kubeconfig when nil)
+	Client kubernetes.Interface
+
+	conf      *config.CfiConfig
+	log       log.Logger
+	namespace string
+	name      string
+	ttl       time.Duration
+
+	mu       sync.Mutex
+	leader   bool
+	eligible bool
+	cancel   context.CancelFunc
+	wake     chan struct{}
+}
+
+// ResolveInstance fills the target instance settings from the node's providerID
+func (e *Elector) ResolveInstance(conf *config.CfiConfig) error {
+	if conf.KubeNode == "" {
+		return fmt.Errorf("kube election requires a node name (kube-node)")
+	}
+
+	if err := e.client(conf); err != nil {
+		return err
+	}
+
+	node, err := e.Client.CoreV1().Nodes().Get(conf.KubeNode, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %s: %v", conf.KubeNode, err)
+	}
+
+	return ParseProviderID(node.Spec.ProviderID, conf)
+}
+
+// ParseProviderID fills conf's unset hoster, instance, zone, region and project
+// from a node's providerID (aws:///<zone>/<instance> or gce://<project>/<zone>/<instance>)
+func ParseProviderID(providerID string, conf *config.CfiConfig) error {
+	parts := strings.SplitN(providerID, "://", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid providerID: '%s'", providerID)
+	}
+
+	fields := strings.Split(strings.Trim(parts[1], "/"), "/")
+
+	switch parts[0] {
+	case "aws":
+		instance := fields[len(fields)-1]
+		if !strings.HasPrefix(instance, "i-") {
+			return fmt.Errorf("invalid aws providerID: '%s'", providerID)
+		}
+		setIfEmpty(&conf.Instance, instance)
+
+		if len(fields) > 1 && fields[0] != "" {
+			zone := fields[0]
+			setIfEmpty(&conf.Zone, zone)
+			setIfEmpty(&conf.Region, zone[:len(zone)-1])
+		}
+	case "gce":
+		if len(fields) != 3 {
+			return fmt.Errorf("invalid gce providerID: '%s'", providerID)
+		}
+		setIfEmpty(&conf.Project, fields[0])
+		setIfEmpty(&conf.Zone, fields[1])
+		setIfEmpty(&conf.Instance, fields[2])
+	default:
+		return fmt.Errorf("unsupported providerID: '%s'", providerID)
+	}
+
+	setIfEmpty(&conf.Hoster, parts[0])
+
+	return nil
+}
+
+func setIfEmpty(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+// Init prepares the Kubernetes Lease elector for usage
+func (e *Elector) Init(conf *config.CfiConfig, h hoster.Hoster, logger log.Logger) error {
+	e.conf = conf
+	e.log = logger
+	e.eligible = true
+	e.wake = make(chan struct{}, 1)
+
+	if err := e.client(conf); err != nil {
+		return err
+	}
+
+	e.namespace = conf.KubeNamespace
+	if e.namespace == "" {
+		e.namespace = defaultNamespace
+	}
+
+	e.name = conf.KubeLease
+	if e.name == "" {
+		e.name = leasePrefix + strings.Replace(conf.IP, ".", "-", -1)
+	}
+
+	e.ttl = conf.LeaseDuration
+	if e.ttl <= 0 {
+		e.ttl = defaultLeaseDuration
+	}
+
+	return nil
+}
+
+func (e *Elector) client(conf *config.CfiConfig) error {
+	if e.Client != nil {
+		return nil
+	}
+
+	var cfg *rest.Config
+	var err error
+	if conf.KubeConfig != "" {
+		cfg, err = clientcmd.BuildConfigFromFlags("", conf.KubeConfig)
+	} else {
+		cfg, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to build a kubernetes client config: %v", err)
+	}
+
+	e.Client, err = kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create a kubernetes client: %v", err)
+	}
+
+	return nil
+}
+
+// Run campaigns for the Lease (while we're eligible), until ctx is cancelled
+func (e *Elector) Run(ctx context.Context, changed chan<- bool) error {
+	for {
+		if campaign, cancel, ok := e.campaignContext(ctx); ok {
+			le, err := e.newLeaderElector(changed)
+			if err != nil {
+				cancel()
+				return err
+			}
+
+			// returns when the context is cancelled, or the lease is lost
+			le.Run(campaign)
+			cancel()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-e.wake:
+		case <-time.After(e.ttl / 5):
+		}
+	}
+}
+
+func (e *Elector) newLeaderElector(changed chan<- bool) (*leaderelection.LeaderElector, error) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{Name: e.name, Namespace: e.namespace},
+		Client:    e.Client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: e.conf.Instance,
+		},
+	}
+
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   e.ttl,
+		RenewDeadline:   e.ttl * 2 / 3,
+		RetryPeriod:     e.ttl / 5,
+		ReleaseOnCancel: true,
+		Name:            e.name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) { e.setLeader(true, changed) },
+			OnStoppedLeading: func() { e.setLeader(false, changed) },
+			OnNewLeader: func(identity string) {
+				e.log.Infof("Lease %s/%s is held by %s\n", e.namespace, e.name, identity)
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a leader elector: %v", err)
+	}
+
+	return le, nil
+}
+
+// campaignContext returns a context cancelled when we're not eligible anymore
+func (e *Elector) campaignContext(ctx context.Context) (context.Context, context.CancelFunc, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.eligible {
+		return nil, nil, false
+	}
+
+	campaign, cancel := context.WithCancel(ctx)
+	e.cancel = cancel
+
+	return campaign, cancel, true
+}
+
+func (e *Elector) setLeader(leader bool, changed chan<- bool) {
+	e.mu.Lock()
+	e.leader = leader
+	e.mu.Unlock()
+
+	if leader {
+		e.log.Infof("Acquired lease %s/%s\n", e.namespace, e.name)
+	} else {
+		e.log.Infof("Lost lease %s/%s\n", e.namespace, e.name)
+	}
+
+	select {
+	case changed <- leader:
+	default:
+	}
+}
+
+// Leader returns true while we hold the Lease
+func (e *Elector) Leader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// SetEligible withdraws (releasing the Lease) or restores our candidacy
+func (e *Elector) SetEligible(eligible bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.eligible == eligible {
+		return
+	}
+	e.eligible = eligible
+
+	if !eligible && e.cancel != nil {
+		e.cancel()
+		return
+	}
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Fence verifies that the Lease is still held by us
+func (e *Elector) Fence() error {
+	lease, err := e.Client.CoordinationV1().Leases(e.namespace).Get(e.name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get lease %s/%s: %v", e.namespace, e.name, err)
+	}
+
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != e.conf.Instance {
+		return fmt.Errorf("lease %s/%s isn't held by %s", e.namespace, e.name, e.conf.Instance)
+	}
+
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return fmt.Errorf("lease %s/%s has no renew time", e.namespace, e.name)
+	}
+
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	if !time.Now().Before(expiry) {
+		return fmt.Errorf("lease %s/%s expired", e.namespace, e.name)
+	}
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/log/console"
+)
+
+func TestParseProviderID(t *testing.T) {
+	tests := []struct {
+		providerID string
+		want       [5]string // hoster, instance, zone, region, project
+		wantErr    bool
+	}{
+		{"aws:///eu-west-1a/i-0123", [5]string{"aws", "i-0123", "eu-west-1a", "eu-west-1", ""}, false},
+		{"aws:///i-0123", [5]string{"aws", "i-0123", "", "", ""}, false},
+		{"gce://proj/europe-west1-b/node-1", [5]string{"gce", "node-1", "europe-west1-b", "", "proj"}, false},
+		{"aws:///eu-west-1a/node-1", [5]string{}, true},
+		{"gce://proj/node-1", [5]string{}, true},
+		{"azure:///sub/vm", [5]string{}, true},
+		{"i-0123", [5]string{}, true},
+	}
+
+	for _, tt := range tests {
+		conf := config.CfiConfig{}
+		err := ParseProviderID(tt.providerID, &conf)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseProviderID(%s) = %v, want error: %v", tt.providerID, err, tt.wantErr)
+			continue
+		}
+		got := [5]string{conf.Hoster, conf.Instance, conf.Zone, conf.Region, conf.Project}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseProviderID(%s) = %v, want %v", tt.providerID, got, tt.want)
+		}
+	}
+}
+
+func TestParseProviderIDKeepsSettings(t *testing.T) {
+	conf := config.CfiConfig{Zone: "eu-west-1b", Instance: "i-4567"}
+	if err := ParseProviderID("aws:///eu-west-1a/i-0123", &conf); err != nil {
+		t.Fatalf("ParseProviderID() = %v", err)
+	}
+
+	if conf.Zone != "eu-west-1b" || conf.Instance != "i-4567" || conf.Region != "eu-west-1" {
+		t.Errorf("ParseProviderID() overrode explicit settings: %+v", conf)
+	}
+}
+
+func TestResolveInstance(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Spec:       corev1.NodeSpec{ProviderID: "aws:///eu-west-1a/i-0123"},
+	}
+
+	e := &Elector{Client: fake.NewSimpleClientset(node)}
+	conf := &config.CfiConfig{KubeNode: "node-1"}
+	if err := e.ResolveInstance(conf); err != nil {
+		t.Fatalf("ResolveInstance() = %v", err)
+	}
+
+	if conf.Instance != "i-0123" || conf.Hoster != "aws" {
+		t.Errorf("ResolveInstance() resolved %+v", conf)
+	}
+
+	if err := e.ResolveInstance(&config.CfiConfig{KubeNode: "node-2"}); err == nil {
+		t.Error("ResolveInstance() of a missing node didn't fail")
+	}
+}
+
+// waitChange returns the next leadership change, failing after timeout
+func waitChange(t *testing.T, changed <-chan bool, timeout time.Duration) bool {
+	select {
+	case leader := <-changed:
+		return leader
+	case <-time.After(timeout):
+		t.Fatalf("no leadership change after %s", timeout)
+	}
+	return false
+}
+
+// between waits until the renewal that starts with a leadership is over:
+// client-go v11 races when an elector is cancelled during a renewal
+func between(e *Elector) {
+	time.Sleep(e.ttl / 10)
+}
+
+func newElector(t *testing.T, client *fake.Clientset, instance string) *Elector {
+	e := &Elector{Client: client}
+	conf := &config.CfiConfig{
+		IP:            "10.200.0.1",
+		Instance:      instance,
+		LeaseDuration: time.Second,
+	}
+
+	if err := e.Init(conf, nil, &console.Logger{Quiet: true}); err != nil {
+		t.Fatalf("Init() = %v", err)
+	}
+
+	return e
+}
+
+func TestElection(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	e := newElector(t, client, "i-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan bool, 1)
+	go e.Run(ctx, changed)
+
+	// acquire
+	if !waitChange(t, changed, 5*time.Second) || !e.Leader() {
+		t.Fatal("the lease wasn't acquired")
+	}
+	if err := e.Fence(); err != nil {
+		t.Errorf("Fence() = %v while holding the lease", err)
+	}
+
+	leases := client.CoordinationV1().Leases(defaultNamespace)
+	lease, err := leases.Get("cloud-floating-ip-10-200-0-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the lease: %v", err)
+	}
+	acquired := lease.Spec.RenewTime.Time
+
+	// renew
+	time.Sleep(1500 * time.Millisecond)
+	lease, err = leases.Get("cloud-floating-ip-10-200-0-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the lease: %v", err)
+	}
+	if !e.Leader() || !lease.Spec.RenewTime.After(acquired) {
+		t.Errorf("the lease wasn't renewed (leader: %v, renewed %s)", e.Leader(), lease.Spec.RenewTime)
+	}
+
+	// lose: another holder takes the lease over
+	holder, duration := "i-2", int32(60)
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec = coordinationv1.LeaseSpec{
+		HolderIdentity:       &holder,
+		LeaseDurationSeconds: &duration,
+		AcquireTime:          &now,
+		RenewTime:            &now,
+	}
+	if _, err = leases.Update(lease); err != nil {
+		t.Fatalf("failed to update the lease: %v", err)
+	}
+
+	if waitChange(t, changed, 5*time.Second) || e.Leader() {
+		t.Fatal("the lease loss wasn't noticed")
+	}
+	if err := e.Fence(); err == nil {
+		t.Error("Fence() didn't fail while another instance holds the lease")
+	}
+}
+
+func TestSetEligible(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	e := newElector(t, client, "i-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan bool, 1)
+	go e.Run(ctx, changed)
+
+	if !waitChange(t, changed, 5*time.Second) {
+		t.Fatal("the lease wasn't acquired")
+	}
+
+	between(e)
+
+	// withdrawing releases the lease, so a standby takes over right away
+	e.SetEligible(false)
+	if waitChange(t, changed, 5*time.Second) || e.Leader() {
+		t.Fatal("the lease wasn't released")
+	}
+
+	standby := newElector(t, client, "i-2")
+	standbyChanged := make(chan bool, 1)
+	go standby.Run(ctx, standbyChanged)
+
+	if !waitChange(t, standbyChanged, 5*time.Second) {
+		t.Fatal("the standby didn't acquire the released lease")
+	}
+
+	between(standby)
+}
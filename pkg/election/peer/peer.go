@@ -0,0 +1,434 @@
+// Package peer implements a VRRP-like election: peers exchange HMAC signed
+// heartbeats over unicast UDP, and the live peer with the highest priority
+// wins (unless nopreempt is set, or a home instance takes the IP back).
+// It doesn't need any external store.
+package peer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/hoster"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+)
+
+const (
+	defaultAdvertInterval = time.Second
+	defaultListen         = ":9876"
+	defaultPriority       = 100
+	maxPacketSize         = 1024
+	maxClockSkew          = 30 * time.Second
+)
+
+// Elector represents a peer heartbeat election backend
+type Elector struct {
+	conf     *config.CfiConfig
+	log      log.Logger
+	key      []byte
+	peers    []*net.UDPAddr
+	advert   time.Duration
+	priority int
+
+	mu       sync.Mutex
+	conn     *net.UDPConn
+	seen     map[string]*peerState
+	started  time.Time
+	eligible bool
+	since    time.Time
+	leader   bool
+	owner    bool
+}
+
+type peerState struct {
+	advert
+	last time.Time
+}
+
+// advert is the heartbeat payload, prefixed by its HMAC on the wire
+type advert struct {
+	ID       string `json:"id"`
+	IP       string `json:"ip"`
+	Priority int    `json:"priority"`
+	Master   bool   `json:"master"`
+	Owner    bool   `json:"owner"`
+	Time     int64  `json:"time"`
+}
+
+// Init prepares the peer elector for usage
+func (e *Elector) Init(conf *config.CfiConfig, h hoster.Hoster, logger log.Logger) error {
+	e.conf = conf
+	e.log = logger
+	e.seen = make(map[string]*peerState)
+	e.eligible = true
+
+	if conf.AuthKey == "" {
+		return errors.New("peer election requires an auth-key")
+	}
+	e.key = []byte(conf.AuthKey)
+
+	if len(conf.Peers) == 0 {
+		return errors.New("peer election requires a list of peers")
+	}
+
+	e.peers = nil
+	for _, p := range conf.Peers {
+		addr, err := net.ResolveUDPAddr("udp", p)
+		if err != nil {
+			return fmt.Errorf("failed to resolve peer %s: %v", p, err)
+		}
+		e.peers = append(e.peers, addr)
+	}
+
+	e.advert = conf.AdvertInterval
+	if e.advert <= 0 {
+		e.advert = defaultAdvertInterval
+	}
+
+	e.priority = conf.Priority
+	if e.priority <= 0 {
+		e.priority = defaultPriority
+	}
+
+	return nil
+}
+
+// Run listens for peers adverts and sends ours until ctx is cancelled
+func (e *Elector) Run(ctx context.Context, changed chan<- bool) error {
+	listen := e.conf.PeerListen
+	if listen == "" {
+		listen = defaultListen
+	}
+
+	laddr, err := net.ResolveUDPAddr("udp", listen)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %v", listen, err)
+	}
+
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", listen, err)
+	}
+
+	e.mu.Lock()
+	e.conn = conn
+	e.started = time.Now()
+	e.since = e.started
+	e.mu.Unlock()
+
+	go e.receive(conn)
+
+	ticker := time.NewTicker(e.advert)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			e.resign()
+			return conn.Close()
+		case <-ticker.C:
+			if leader, ok := e.elect(); ok {
+				select {
+				case changed <- leader:
+				default:
+				}
+			}
+			e.send(e.self())
+		}
+	}
+}
+
+// Leader returns true when we're the elected master
+func (e *Elector) Leader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// SetEligible withdraws or restores our candidacy (we advertise a zero priority when not eligible)
+func (e *Elector) SetEligible(eligible bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if eligible && !e.eligible {
+		e.since = time.Now()
+	}
+	e.eligible = eligible
+}
+
+// Report publishes whether the routes target us, in our next heartbeats
+func (e *Elector) Report(owner bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.owner = owner
+}
+
+// Owners returns the live peers whose heartbeats report they own the routes
+func (e *Elector) Owners() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var owners []string
+	for _, p := range e.seen {
+		if p.Owner && time.Since(p.last) <= e.masterDownInterval() {
+			owners = append(owners, p.ID)
+		}
+	}
+	sort.Strings(owners)
+
+	return owners
+}
+
+// Master returns whether the instance is a live and eligible master, as
+// seen from its heartbeats, and whether it's a known peer
+func (e *Elector) Master(instance string) (bool, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if instance == e.conf.Instance {
+		return e.leader, true
+	}
+
+	p, ok := e.seen[instance]
+	if !ok {
+		return false, false
+	}
+
+	return p.Master && p.Priority > 0 && time.Since(p.last) <= e.masterDownInterval(), true
+}
+
+// Standby returns the live, eligible peer with the highest priority
+func (e *Elector) Standby() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var best *peerState
+	for _, p := range e.seen {
+		if p.Priority <= 0 || time.Since(p.last) > e.masterDownInterval() {
+			continue
+		}
+
+		if best == nil || p.Priority > best.Priority || (p.Priority == best.Priority && p.ID > best.ID) {
+			best = p
+		}
+	}
+
+	if best == nil {
+		return ""
+	}
+
+	return best.ID
+}
+
+// elect computes the current leadership, and returns true as second value on transitions
+func (e *Elector) elect() (bool, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	down := e.masterDownInterval()
+
+	// give peers a chance to advertise before claiming mastership
+	leader := e.eligible && now.Sub(e.started) >= down
+
+	for _, p := range e.seen {
+		if !leader || e.failback(now) {
+			break
+		}
+
+		if now.Sub(p.last) > down || p.Priority <= 0 {
+			continue
+		}
+
+		if e.yields(p) {
+			leader = false
+		}
+	}
+
+	if leader == e.leader {
+		return leader, false
+	}
+
+	if leader {
+		e.log.Infof("Peer election: %s is now master for %s\n", e.conf.Instance, e.conf.IP)
+	} else {
+		e.log.Infof("Peer election: %s is now backup for %s\n", e.conf.Instance, e.conf.IP)
+	}
+
+	e.leader = leader
+	return leader, true
+}
+
+// failback returns true when we're the home instance, and were eligible long enough to take the IP back
+func (e *Elector) failback(now time.Time) bool {
+	return e.conf.Home != "" && e.conf.Home == e.conf.Instance && now.Sub(e.since) >= e.conf.FailbackDelay
+}
+
+// yields returns true when a live, eligible peer should be master rather than us
+func (e *Elector) yields(p *peerState) bool {
+	better := p.Priority > e.priority || (p.Priority == e.priority && p.ID > e.conf.Instance)
+
+	switch {
+	case p.Master && p.ID == e.conf.Home:
+		// the home instance took the IP back
+		return true
+	case p.Master && better:
+		// several masters: the best one stays
+		return true
+	case p.Master && e.conf.NoPreempt && !e.leader:
+		// don't preempt a live master
+		return true
+	case p.Master && e.conf.Home == e.conf.Instance && !e.leader:
+		// we're home, but weren't eligible for the failback delay yet
+		return true
+	case p.ID == e.conf.Home && e.leader:
+		// the home instance takes the IP back by itself, after the failback delay
+		return false
+	case better && !e.conf.NoPreempt:
+		return true
+	}
+
+	return false
+}
+
+// masterDownInterval is the delay after which a silent peer is considered dead
+func (e *Elector) masterDownInterval() time.Duration {
+	return 3 * e.advert
+}
+
+func (e *Elector) self() *advert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	priority := e.priority
+	if !e.eligible {
+		priority = 0
+	}
+
+	return &advert{
+		ID:       e.conf.Instance,
+		IP:       e.conf.IP,
+		Priority: priority,
+		Master:   e.leader,
+		Owner:    e.owner,
+		Time:     time.Now().UnixNano(),
+	}
+}
+
+// resign tells peers we're leaving (zero priority), so they don't wait for our timeout
+func (e *Elector) resign() {
+	adv := e.self()
+	adv.Priority = 0
+	adv.Master = false
+
+	e.mu.Lock()
+	e.leader = false
+	e.mu.Unlock()
+
+	e.send(adv)
+}
+
+func (e *Elector) send(adv *advert) {
+	packet, err := e.sign(adv)
+	if err != nil {
+		e.log.Errorf("Failed to encode advert: %v\n", err)
+		return
+	}
+
+	e.mu.Lock()
+	conn := e.conn
+	e.mu.Unlock()
+
+	for _, peer := range e.peers {
+		if _, err := conn.WriteToUDP(packet, peer); err != nil {
+			e.log.Errorf("Failed to send advert to %s: %v\n", peer, err)
+		}
+	}
+}
+
+func (e *Elector) receive(conn *net.UDPConn) {
+	buf := make([]byte, maxPacketSize)
+
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// the connection is closed when Run returns
+			return
+		}
+
+		adv, err := e.verify(buf[:n])
+		if err != nil {
+			e.log.Errorf("Discarding advert from %s: %v\n", from, err)
+			continue
+		}
+
+		e.record(adv)
+	}
+}
+
+// record stores a verified peer advert, discarding replays
+func (e *Elector) record(adv *advert) {
+	if adv.IP != e.conf.IP || adv.ID == e.conf.Instance {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	prev, ok := e.seen[adv.ID]
+	if ok && adv.Time <= prev.Time {
+		return
+	}
+
+	if !ok || prev.Priority != adv.Priority || prev.Master != adv.Master {
+		e.log.Infof("Peer %s: priority %d, master: %v\n", adv.ID, adv.Priority, adv.Master)
+	}
+
+	e.seen[adv.ID] = &peerState{advert: *adv, last: time.Now()}
+}
+
+func (e *Elector) sign(adv *advert) ([]byte, error) {
+	payload, err := json.Marshal(adv)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, e.key)
+	_, _ = mac.Write(payload)
+
+	return append(mac.Sum(nil), payload...), nil
+}
+
+func (e *Elector) verify(packet []byte) (*advert, error) {
+	if len(packet) <= sha256.Size {
+		return nil, errors.New("packet too short")
+	}
+
+	sum, payload := packet[:sha256.Size], packet[sha256.Size:]
+
+	mac := hmac.New(sha256.New, e.key)
+	_, _ = mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, errors.New("invalid signature")
+	}
+
+	adv := &advert{}
+	if err := json.Unmarshal(payload, adv); err != nil {
+		return nil, fmt.Errorf("invalid payload: %v", err)
+	}
+
+	skew := time.Since(time.Unix(0, adv.Time))
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, fmt.Errorf("advert timestamp is off by %s", skew)
+	}
+
+	return adv, nil
+}
@@ -0,0 +1,92 @@
+package peer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/log/console"
+)
+
+func TestElect(t *testing.T) {
+	now := time.Now()
+	live, dead := now, now.Add(-time.Minute)
+
+	tests := []struct {
+		name      string
+		home      string
+		nopreempt bool
+		leader    bool
+		since     time.Time
+		peer      *peerState
+		want      bool
+	}{
+		{"alone", "", false, false, dead, nil, true},
+		{"better peer", "", false, false, dead,
+			&peerState{advert{ID: "i-2", Priority: 150}, live}, false},
+		{"worse peer", "", false, false, dead,
+			&peerState{advert{ID: "i-2", Priority: 50}, live}, true},
+		{"tie broken by name", "", false, false, dead,
+			&peerState{advert{ID: "i-0", Priority: 100}, live}, true},
+		{"dead better peer", "", false, false, dead,
+			&peerState{advert{ID: "i-2", Priority: 150}, dead}, true},
+		{"ineligible better peer", "", false, false, dead,
+			&peerState{advert{ID: "i-2", Priority: 0}, live}, true},
+		{"nopreempt master keeps the IP", "", true, true, dead,
+			&peerState{advert{ID: "i-2", Priority: 150}, live}, true},
+		{"nopreempt doesn't preempt a live master", "", true, false, dead,
+			&peerState{advert{ID: "i-2", Priority: 50, Master: true}, live}, false},
+		{"several masters, the best stays", "", false, true, dead,
+			&peerState{advert{ID: "i-2", Priority: 150, Master: true}, live}, false},
+		{"home waits for the failback delay", "i-1", false, false, now,
+			&peerState{advert{ID: "i-2", Priority: 50, Master: true}, live}, false},
+		{"home takes the IP back", "i-1", false, false, dead,
+			&peerState{advert{ID: "i-2", Priority: 150, Master: true}, live}, true},
+		{"master keeps the IP until home takes it back", "i-2", false, true, dead,
+			&peerState{advert{ID: "i-2", Priority: 150}, live}, true},
+		{"home took the IP back", "i-2", false, true, dead,
+			&peerState{advert{ID: "i-2", Priority: 50, Master: true}, live}, false},
+	}
+
+	for _, tt := range tests {
+		e := &Elector{
+			conf: &config.CfiConfig{
+				Instance:      "i-1",
+				Home:          tt.home,
+				NoPreempt:     tt.nopreempt,
+				FailbackDelay: 30 * time.Second,
+			},
+			log:      &console.Logger{Quiet: true},
+			advert:   time.Second,
+			priority: defaultPriority,
+			seen:     make(map[string]*peerState),
+			started:  dead,
+			since:    tt.since,
+			eligible: true,
+			leader:   tt.leader,
+		}
+		if tt.peer != nil {
+			e.seen[tt.peer.ID] = tt.peer
+		}
+
+		if got, _ := e.elect(); got != tt.want {
+			t.Errorf("%s: elect() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestElectWaitsForAdverts(t *testing.T) {
+	e := &Elector{
+		conf:     &config.CfiConfig{Instance: "i-1"},
+		log:      &console.Logger{Quiet: true},
+		advert:   time.Second,
+		priority: defaultPriority,
+		seen:     make(map[string]*peerState),
+		started:  time.Now(),
+		eligible: true,
+	}
+
+	if leader, _ := e.elect(); leader {
+		t.Error("elect() claimed mastership before peers could advertise")
+	}
+}
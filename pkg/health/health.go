@@ -0,0 +1,128 @@
+// Package health runs local probes, and tells whether the instance is
+// healthy enough to carry the floating IP (honoring rise/fall thresholds).
+package health
+
+import (
+	"context"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+)
+
+const (
+	defaultRise = 2
+	defaultFall = 3
+)
+
+type check struct {
+	probe   Probe
+	timeout time.Duration
+}
+
+// Checker aggregates probes results into an healthy/unhealthy state
+type Checker struct {
+	checks    []check
+	rise      int
+	fall      int
+	log       log.Logger
+	healthy   bool
+	started   bool
+	successes int
+	failures  int
+}
+
+// NewChecker returns a checker running the configured health checks
+func NewChecker(conf *config.CfiConfig, logger log.Logger) (*Checker, error) {
+	c := &Checker{
+		rise: conf.HealthRise,
+		fall: conf.HealthFall,
+		log:  logger,
+	}
+
+	if c.rise <= 0 {
+		c.rise = defaultRise
+	}
+
+	if c.fall <= 0 {
+		c.fall = defaultFall
+	}
+
+	for _, hc := range conf.HealthChecks {
+		probe, err := NewProbe(hc)
+		if err != nil {
+			return nil, err
+		}
+
+		timeout := hc.Timeout
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+
+		c.checks = append(c.checks, check{probe: probe, timeout: timeout})
+	}
+
+	return c, nil
+}
+
+// Enabled returns true when at least one health check is configured
+func (c *Checker) Enabled() bool {
+	return len(c.checks) > 0
+}
+
+// Healthy returns the current health state, without running the probes
+func (c *Checker) Healthy() bool {
+	return !c.Enabled() || c.healthy
+}
+
+// Check runs all probes and returns the updated health state. The first
+// check sets the state directly; later ones must reach the rise or fall
+// threshold to change it.
+func (c *Checker) Check() bool {
+	if !c.Enabled() {
+		return true
+	}
+
+	ok := c.runProbes()
+
+	if !c.started {
+		c.started = true
+		c.healthy = ok
+		return c.healthy
+	}
+
+	if ok {
+		c.successes++
+		c.failures = 0
+	} else {
+		c.failures++
+		c.successes = 0
+	}
+
+	if !c.healthy && c.successes >= c.rise {
+		c.log.Infof("Health checks passed %d times, instance is healthy\n", c.successes)
+		c.healthy = true
+	}
+
+	if c.healthy && c.failures >= c.fall {
+		c.log.Infof("Health checks failed %d times, instance is unhealthy\n", c.failures)
+		c.healthy = false
+	}
+
+	return c.healthy
+}
+
+func (c *Checker) runProbes() bool {
+	for _, chk := range c.checks {
+		ctx, cancel := context.WithTimeout(context.Background(), chk.timeout)
+		err := chk.probe.Check(ctx)
+		cancel()
+
+		if err != nil {
+			c.log.Errorf("Health check %s failed: %v\n", chk.probe, err)
+			return false
+		}
+	}
+
+	return true
+}
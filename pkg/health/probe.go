@@ -0,0 +1,150 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/shell"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	maxBodySize    = 1 << 20
+)
+
+// Probe checks the health of a local service
+type Probe interface {
+	Check(ctx context.Context) error
+	String() string
+}
+
+// NewProbe returns the probe described by a health check configuration
+func NewProbe(hc config.HealthCheck) (Probe, error) {
+	switch hc.Type {
+	case "tcp":
+		if hc.Address == "" {
+			return nil, fmt.Errorf("tcp health check requires an address")
+		}
+		return &tcpProbe{address: hc.Address}, nil
+	case "http":
+		return newHTTPProbe(hc)
+	case "exec":
+		if hc.Command == "" {
+			return nil, fmt.Errorf("exec health check requires a command")
+		}
+		return &execProbe{command: hc.Command}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported health check type: '%s'", hc.Type)
+}
+
+type tcpProbe struct {
+	address string
+}
+
+func (p *tcpProbe) Check(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+func (p *tcpProbe) String() string {
+	return "tcp " + p.address
+}
+
+type httpProbe struct {
+	url    string
+	status int
+	body   *regexp.Regexp
+	client *http.Client
+}
+
+func newHTTPProbe(hc config.HealthCheck) (*httpProbe, error) {
+	if hc.URL == "" {
+		return nil, fmt.Errorf("http health check requires an url")
+	}
+
+	p := &httpProbe{
+		url:    hc.URL,
+		status: hc.ExpectStatus,
+		client: &http.Client{},
+	}
+
+	if p.status == 0 {
+		p.status = http.StatusOK
+	}
+
+	if hc.ExpectBody != "" {
+		re, err := regexp.Compile(hc.ExpectBody)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expect-body regexp: %v", err)
+		}
+		p.body = re
+	}
+
+	return p, nil
+}
+
+func (p *httpProbe) Check(ctx context.Context) error {
+	req, err := http.NewRequest("GET", p.url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != p.status {
+		return fmt.Errorf("unexpected status code %d (expected %d)", resp.StatusCode, p.status)
+	}
+
+	if p.body == nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: maxBodySize})
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if !p.body.Match(body) {
+		return fmt.Errorf("response body doesn't match %s", p.body)
+	}
+
+	return nil
+}
+
+func (p *httpProbe) String() string {
+	return "http " + p.url
+}
+
+type execProbe struct {
+	command string
+}
+
+func (p *execProbe) Check(ctx context.Context) error {
+	out, err := shell.Run(ctx, p.command, nil, nil)
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+
+	return nil
+}
+
+func (p *execProbe) String() string {
+	return "exec " + p.command
+}
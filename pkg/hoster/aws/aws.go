@@ -5,6 +5,7 @@ import (
 
 	"github.com/bpineau/cloud-floating-ip/config"
 	"github.com/bpineau/cloud-floating-ip/pkg/log"
+	"github.com/bpineau/cloud-floating-ip/pkg/pin"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
@@ -23,6 +24,9 @@ type Hoster struct {
 	cidr   *string
 	vpc    string
 	myip   string
+
+	// pinDenied is set once we reported we can't read pins
+	pinDenied bool
 }
 
 type routeStatus int
@@ -36,17 +40,21 @@ const (
 )
 
 // Init prepare an aws hoster for usage
-func (h *Hoster) Init(conf *config.CfiConfig, logger log.Logger) {
+func (h *Hoster) Init(conf *config.CfiConfig, logger log.Logger) error {
 	h.conf = conf
 	h.log = logger
 	err := h.checkMissingParam()
 	if err != nil {
-		h.log.Fatalf("Missing param: %v\n", err)
+		return fmt.Errorf("missing param: %v", err)
+	}
+
+	if err = h.checkFenceActions(); err != nil {
+		return fmt.Errorf("invalid fencing: %v", err)
 	}
 
 	h.sess, err = session.NewSession(aws.NewConfig().WithMaxRetries(3))
 	if err != nil {
-		h.log.Fatalf("Failed to initialize an AWS session: %v\n", err)
+		return fmt.Errorf("failed to initialize an AWS session: %v", err)
 	}
 
 	metadata := ec2metadata.New(h.sess)
@@ -54,7 +62,7 @@ func (h *Hoster) Init(conf *config.CfiConfig, logger log.Logger) {
 	if h.conf.Region == "" {
 		h.conf.Region, err = metadata.Region()
 		if err != nil {
-			h.log.Fatalf("Failed to collect region from instance metadata: %v\n", err)
+			return fmt.Errorf("failed to collect region from instance metadata: %v", err)
 		}
 	}
 
@@ -63,7 +71,7 @@ func (h *Hoster) Init(conf *config.CfiConfig, logger log.Logger) {
 	if h.conf.Instance == "" {
 		h.conf.Instance, err = metadata.GetMetadata("instance-id")
 		if err != nil {
-			h.log.Fatalf("Failed to collect instanceid from instance metadata: %v\n", err)
+			return fmt.Errorf("failed to collect instanceid from instance metadata: %v", err)
 		}
 	}
 
@@ -71,8 +79,10 @@ func (h *Hoster) Init(conf *config.CfiConfig, logger log.Logger) {
 
 	err = h.getNetworkInfo()
 	if err != nil {
-		h.log.Fatalf("Failed to collect network infos: %v\n", err)
+		return fmt.Errorf("failed to collect network infos: %v", err)
 	}
+
+	return nil
 }
 
 func (h *Hoster) getNetworkInfo() error {
@@ -89,6 +99,12 @@ func (h *Hoster) getNetworkInfo() error {
 	cidr := h.conf.IP + "/32"
 	h.cidr = &cidr
 
+	return h.refreshRouteTables()
+}
+
+// refreshRouteTables (re)loads the VPC's route tables, so we can spot changes
+// made since Init (eg. when running as a daemon).
+func (h *Hoster) refreshRouteTables() error {
 	input := &ec2.DescribeRouteTablesInput{
 		Filters: []*ec2.Filter{
 			&ec2.Filter{
@@ -103,11 +119,13 @@ func (h *Hoster) getNetworkInfo() error {
 		return fmt.Errorf("failed to DescribeRouteTables: %v", err)
 	}
 
-	h.routes = h.filterRouteTables(routes.RouteTables)
-	if len(h.routes) == 0 {
+	tables := h.filterRouteTables(routes.RouteTables)
+	if len(tables) == 0 {
 		return fmt.Errorf("no route table left after filtering")
 	}
 
+	h.routes = tables
+
 	return nil
 }
 
@@ -221,24 +239,28 @@ func (h *Hoster) Preempt() error {
 		return nil
 	}
 
+	if err := pin.Verify(h, h.conf.Instance, h.conf.Force); err != nil {
+		return err
+	}
+
 	h.log.Infof("Preempting %s route(s)\n", h.conf.IP)
 
 	for _, table := range h.routes {
 		var err error
 
-		status := isRouteInTable(table, h.cidr, h.enid, h.conf.Instance)
+		status, _ := isRouteInTable(table, h.cidr, h.enid, h.conf.Instance)
 
 		switch status {
 		case rsCorrectTarget:
 			continue
 		case rsAbsent:
-			err = h.addRouteInTable(table, h.cidr, h.enid)
+			err = h.addRouteInTable(table, h.cidr, h.enid, h.conf.Instance)
 		case rsWrongTarget:
-			err = h.replaceRouteInTable(table, h.cidr, h.enid)
+			err = h.replaceRouteInTable(table, h.cidr, h.enid, h.conf.Instance)
 		}
 
 		if err != nil {
-			h.log.Fatalf("Failed to create a route: %v\n", err)
+			return err
 		}
 	}
 
@@ -247,8 +269,12 @@ func (h *Hoster) Preempt() error {
 
 // Status returns true if the floating IP address route to the instance
 func (h *Hoster) Status() bool {
+	if err := h.refreshRouteTables(); err != nil {
+		h.log.Errorf("Failed to refresh route tables, using last known state: %v\n", err)
+	}
+
 	for _, table := range h.routes {
-		if isRouteInTable(table, h.cidr, h.enid, h.conf.Instance) != rsCorrectTarget {
+		if status, _ := isRouteInTable(table, h.cidr, h.enid, h.conf.Instance); status != rsCorrectTarget {
 			return false
 		}
 	}
@@ -256,35 +282,78 @@ func (h *Hoster) Status() bool {
 	return true
 }
 
-// Destroy remove route(s) to the IP from our VPC
+// Owner returns the target (instance or ENI ID) of the first route to the IP
+// found in our tables, or an empty string when nobody owns the IP
+func (h *Hoster) Owner() (string, error) {
+	if err := h.refreshRouteTables(); err != nil {
+		return "", err
+	}
+
+	for _, table := range h.routes {
+		for _, route := range table.Routes {
+			if route.DestinationCidrBlock == nil || *route.DestinationCidrBlock != *h.cidr {
+				continue
+			}
+
+			if route.InstanceId != nil {
+				return *route.InstanceId, nil
+			}
+
+			if route.NetworkInterfaceId != nil {
+				return *route.NetworkInterfaceId, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// Destroy removes the routes to the IP targeting the instance from our VPC
+// tables, or all the routes to the IP with DestroyAll. Foreign routes are
+// reported, and checked again right before deletion.
 func (h *Hoster) Destroy() error {
 	for _, table := range h.routes {
-		status := isRouteInTable(table, h.cidr, h.enid, h.conf.Instance)
+		status, route := isRouteInTable(table, h.cidr, h.enid, h.conf.Instance)
 		if status == rsAbsent {
 			continue
 		}
 
-		route := &ec2.DeleteRouteInput{
-			RouteTableId:         table.RouteTableId,
-			DestinationCidrBlock: h.cidr,
+		if status == rsWrongTarget && !h.conf.DestroyAll {
+			h.log.Warnf("Not deleting route to %s from %s table, targeting %s\n",
+				*h.cidr, *table.RouteTableId, routeTargetID(route))
+			continue
 		}
 
-		h.log.Infof("Deleting route to %s from %s table\n",
-			*h.cidr, *table.RouteTableId)
+		if !h.conf.DestroyAll {
+			current, err := h.currentTable(table)
+			if err != nil {
+				return err
+			}
 
-		if h.conf.DryRun {
-			continue
+			if status, _ = isRouteInTable(current, h.cidr, h.enid, h.conf.Instance); status != rsCorrectTarget {
+				h.log.Warnf("Not deleting route to %s from %s table, changed meanwhile\n",
+					*h.cidr, *table.RouteTableId)
+				continue
+			}
 		}
 
-		_, err := h.ec2s.DeleteRoute(route)
-		if err != nil {
-			return fmt.Errorf("Failed to delete route: %v", err)
+		if err := h.deleteRouteInTable(table, h.cidr); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+func (h *Hoster) tableIds() []*string {
+	var ids []*string
+	for _, table := range h.routes {
+		ids = append(ids, table.RouteTableId)
+	}
+
+	return ids
+}
+
 func (h *Hoster) checkMissingParam() error {
 	if h.OnThisHoster() {
 		return nil
@@ -298,7 +367,8 @@ func (h *Hoster) checkMissingParam() error {
 	return nil
 }
 
-func isRouteInTable(table *ec2.RouteTable, cidr *string, eni *string, instance string) routeStatus {
+// isRouteInTable returns the state of the route to cidr in table, and this route (if any)
+func isRouteInTable(table *ec2.RouteTable, cidr *string, eni *string, instance string) (routeStatus, *ec2.Route) {
 	for _, route := range table.Routes {
 		if route.DestinationCidrBlock == nil {
 			continue
@@ -309,20 +379,28 @@ func isRouteInTable(table *ec2.RouteTable, cidr *string, eni *string, instance s
 		}
 
 		if route.InstanceId != nil && instance != "" && *route.InstanceId == instance {
-			return rsCorrectTarget
+			return rsCorrectTarget, route
 		}
 
 		if route.NetworkInterfaceId != nil && eni != nil && *route.NetworkInterfaceId == *eni {
-			return rsCorrectTarget
+			return rsCorrectTarget, route
 		}
 
-		return rsWrongTarget
+		return rsWrongTarget, route
 	}
 
-	return rsAbsent
+	return rsAbsent, nil
 }
 
-func (h *Hoster) addRouteInTable(table *ec2.RouteTable, cidr *string, eni *string) error {
+func (h *Hoster) addRouteInTable(table *ec2.RouteTable, cidr *string, eni *string, instance string) error {
+	if err := h.expectOwner(table); err != nil {
+		return err
+	}
+
+	if err := h.checkLease(instance); err != nil {
+		return err
+	}
+
 	route := &ec2.CreateRouteInput{
 		RouteTableId:         table.RouteTableId,
 		DestinationCidrBlock: cidr,
@@ -336,11 +414,22 @@ func (h *Hoster) addRouteInTable(table *ec2.RouteTable, cidr *string, eni *strin
 		return nil
 	}
 
-	_, err := h.ec2s.CreateRoute(route)
-	return err
+	if _, err := h.ec2s.CreateRoute(route); err != nil {
+		return fmt.Errorf("failed to CreateRoute in %s: %v", *table.RouteTableId, err)
+	}
+
+	return nil
 }
 
-func (h *Hoster) replaceRouteInTable(table *ec2.RouteTable, cidr *string, eni *string) error {
+func (h *Hoster) replaceRouteInTable(table *ec2.RouteTable, cidr *string, eni *string, instance string) error {
+	if err := h.expectOwner(table); err != nil {
+		return err
+	}
+
+	if err := h.checkLease(instance); err != nil {
+		return err
+	}
+
 	route := &ec2.ReplaceRouteInput{
 		RouteTableId:         table.RouteTableId,
 		DestinationCidrBlock: cidr,
@@ -354,8 +443,34 @@ func (h *Hoster) replaceRouteInTable(table *ec2.RouteTable, cidr *string, eni *s
 		return nil
 	}
 
-	_, err := h.ec2s.ReplaceRoute(route)
-	return err
+	if _, err := h.ec2s.ReplaceRoute(route); err != nil {
+		return fmt.Errorf("failed to ReplaceRoute in %s: %v", *table.RouteTableId, err)
+	}
+
+	return nil
+}
+
+func (h *Hoster) deleteRouteInTable(table *ec2.RouteTable, cidr *string) error {
+	if err := h.expectOwner(table); err != nil {
+		return err
+	}
+
+	input := &ec2.DeleteRouteInput{
+		RouteTableId:         table.RouteTableId,
+		DestinationCidrBlock: cidr,
+	}
+
+	h.log.Infof("Deleting route to %s from %s table\n", *cidr, *table.RouteTableId)
+
+	if h.conf.DryRun {
+		return nil
+	}
+
+	if _, err := h.ec2s.DeleteRoute(input); err != nil {
+		return fmt.Errorf("failed to DeleteRoute in %s: %v", *table.RouteTableId, err)
+	}
+
+	return nil
 }
 
 // discard tables attached to the main table if --ignore-main-table is specified,
@@ -0,0 +1,229 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	fenceStop            = "stop"
+	fenceDetach          = "detach"
+	fenceSourceDestCheck = "source-dest-check"
+
+	defaultFenceTimeout = 2 * time.Minute
+
+	blackhole = "blackhole"
+)
+
+// owner is a previous owner of the routes, as found in route targets
+type owner struct {
+	instance string
+	eni      string
+}
+
+func (o owner) String() string {
+	if o.instance == "" {
+		return o.eni
+	}
+
+	return o.instance
+}
+
+func (h *Hoster) checkFenceActions() error {
+	for _, action := range h.conf.Fence {
+		switch action {
+		case fenceStop, fenceDetach, fenceSourceDestCheck:
+		default:
+			return fmt.Errorf("unsupported fence action: '%s'", action)
+		}
+	}
+
+	return nil
+}
+
+// Fence makes sure the instances our routes currently target (as found
+// by isRouteInTable) can't serve the IP anymore, before we take over. Fails
+// before fencing anything when they aren't the expected owner.
+func (h *Hoster) Fence() error {
+	if len(h.conf.Fence) == 0 {
+		return nil
+	}
+
+	timeout := h.conf.FenceTimeout
+	if timeout <= 0 {
+		timeout = defaultFenceTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	seen := make(map[owner]bool)
+
+	for _, table := range h.routes {
+		status, route := isRouteInTable(table, h.cidr, h.enid, h.conf.Instance)
+		if status != rsWrongTarget || (route.State != nil && *route.State == blackhole) {
+			continue
+		}
+
+		if err := h.expectOwner(table); err != nil {
+			return err
+		}
+
+		o, err := h.routeOwner(ctx, route)
+		if err != nil {
+			return err
+		}
+
+		// routes to gateways, peerings etc. have no owner to fence
+		if (o.instance == "" && o.eni == "") || seen[o] || o.instance == h.conf.Instance {
+			continue
+		}
+		seen[o] = true
+
+		for _, action := range h.conf.Fence {
+			if err := h.fence(ctx, action, o); err != nil {
+				return fmt.Errorf("%s %s: %v", action, o, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// routeOwner returns the instance and ENI targeted by a route
+func (h *Hoster) routeOwner(ctx context.Context, route *ec2.Route) (owner, error) {
+	o := owner{
+		instance: aws.StringValue(route.InstanceId),
+		eni:      aws.StringValue(route.NetworkInterfaceId),
+	}
+
+	if o.instance != "" || o.eni == "" {
+		return o, nil
+	}
+
+	iface, err := h.describeInterface(ctx, o.eni)
+	if err != nil {
+		return o, err
+	}
+
+	if iface.Attachment != nil {
+		o.instance = aws.StringValue(iface.Attachment.InstanceId)
+	}
+
+	return o, nil
+}
+
+func (h *Hoster) fence(ctx context.Context, action string, o owner) error {
+	switch action {
+	case fenceStop:
+		return h.stopInstance(ctx, o.instance)
+	case fenceDetach:
+		return h.detachInterface(ctx, o.eni)
+	case fenceSourceDestCheck:
+		return h.enableSourceDestCheck(ctx, o.eni)
+	}
+
+	return fmt.Errorf("unsupported fence action")
+}
+
+// stopInstance force stops the instance, and waits until it's stopped
+func (h *Hoster) stopInstance(ctx context.Context, instance string) error {
+	if instance == "" {
+		// a detached ENI can't serve the IP
+		return nil
+	}
+
+	h.log.Infof("Fencing: stopping instance %s\n", instance)
+
+	if h.conf.DryRun {
+		return nil
+	}
+
+	_, err := h.ec2s.StopInstancesWithContext(ctx, &ec2.StopInstancesInput{
+		InstanceIds: []*string{aws.String(instance)},
+		Force:       aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to StopInstances: %v", err)
+	}
+
+	return h.ec2s.WaitUntilInstanceStoppedWithContext(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(instance)},
+	})
+}
+
+// detachInterface force detaches the ENI from its instance, and waits until it's available
+func (h *Hoster) detachInterface(ctx context.Context, eni string) error {
+	if eni == "" {
+		return fmt.Errorf("the route doesn't target an ENI")
+	}
+
+	iface, err := h.describeInterface(ctx, eni)
+	if err != nil {
+		return err
+	}
+
+	if iface.Attachment == nil || iface.Attachment.AttachmentId == nil {
+		return nil
+	}
+
+	h.log.Infof("Fencing: detaching ENI %s from %s\n", eni, aws.StringValue(iface.Attachment.InstanceId))
+
+	if h.conf.DryRun {
+		return nil
+	}
+
+	_, err = h.ec2s.DetachNetworkInterfaceWithContext(ctx, &ec2.DetachNetworkInterfaceInput{
+		AttachmentId: iface.Attachment.AttachmentId,
+		Force:        aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to DetachNetworkInterface: %v", err)
+	}
+
+	return h.ec2s.WaitUntilNetworkInterfaceAvailableWithContext(ctx, &ec2.DescribeNetworkInterfacesInput{
+		NetworkInterfaceIds: []*string{aws.String(eni)},
+	})
+}
+
+// enableSourceDestCheck stops the ENI from forwarding traffic sent to the floating IP
+func (h *Hoster) enableSourceDestCheck(ctx context.Context, eni string) error {
+	if eni == "" {
+		return fmt.Errorf("the route doesn't target an ENI")
+	}
+
+	h.log.Infof("Fencing: enabling source/destination check on ENI %s\n", eni)
+
+	if h.conf.DryRun {
+		return nil
+	}
+
+	_, err := h.ec2s.ModifyNetworkInterfaceAttributeWithContext(ctx, &ec2.ModifyNetworkInterfaceAttributeInput{
+		NetworkInterfaceId: aws.String(eni),
+		SourceDestCheck:    &ec2.AttributeBooleanValue{Value: aws.Bool(true)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to ModifyNetworkInterfaceAttribute: %v", err)
+	}
+
+	return nil
+}
+
+func (h *Hoster) describeInterface(ctx context.Context, eni string) (*ec2.NetworkInterface, error) {
+	out, err := h.ec2s.DescribeNetworkInterfacesWithContext(ctx, &ec2.DescribeNetworkInterfacesInput{
+		NetworkInterfaceIds: []*string{aws.String(eni)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to DescribeNetworkInterfaces: %v", err)
+	}
+
+	if len(out.NetworkInterfaces) == 0 {
+		return nil, fmt.Errorf("ENI %s not found", eni)
+	}
+
+	return out.NetworkInterfaces[0], nil
+}
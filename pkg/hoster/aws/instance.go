@@ -0,0 +1,58 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// LocalInstance returns the id of the instance we run on, from its metadata
+func (h *Hoster) LocalInstance() (string, error) {
+	return ec2metadata.New(h.sess).GetMetadata("instance-id")
+}
+
+// InstanceHealthy returns true when the instance (or the instance the ENI is
+// attached to), as returned by Owner, is running and its status checks
+// aren't impaired
+func (h *Hoster) InstanceHealthy(instance string) (bool, error) {
+	if strings.HasPrefix(instance, "eni-") {
+		iface, err := h.describeInterface(context.Background(), instance)
+		if err != nil {
+			return false, err
+		}
+
+		if iface.Attachment == nil || iface.Attachment.InstanceId == nil {
+			// a detached ENI can't serve the IP
+			return false, nil
+		}
+
+		instance = *iface.Attachment.InstanceId
+	}
+
+	out, err := h.ec2s.DescribeInstanceStatus(&ec2.DescribeInstanceStatusInput{
+		InstanceIds:         []*string{aws.String(instance)},
+		IncludeAllInstances: aws.Bool(true),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to DescribeInstanceStatus: %v", err)
+	}
+
+	if len(out.InstanceStatuses) == 0 {
+		return false, nil
+	}
+
+	status := out.InstanceStatuses[0]
+	if status.InstanceState == nil || aws.StringValue(status.InstanceState.Name) != ec2.InstanceStateNameRunning {
+		return false, nil
+	}
+
+	return !impaired(status.InstanceStatus) && !impaired(status.SystemStatus), nil
+}
+
+func impaired(summary *ec2.InstanceStatusSummary) bool {
+	return summary != nil && aws.StringValue(summary.Status) == ec2.SummaryStatusImpaired
+}
@@ -0,0 +1,222 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/lease"
+)
+
+const (
+	leaseGroupPrefix = "cloud-floating-ip-lease-for-"
+	leaseTag         = "cloud-floating-ip-lease"
+)
+
+// leaseClaim is the security group claiming a lease generation: the group's
+// description records the lease at claim time, renewals and releases go to
+// its leaseTag tag
+type leaseClaim struct {
+	id         string
+	generation uint64
+	lease      *lease.Lease
+}
+
+// GetLease returns the lease of the highest claimed generation (nil when there's none)
+func (h *Hoster) GetLease() (*lease.Lease, error) {
+	claims, err := h.leaseClaims()
+	if err != nil || len(claims) == 0 {
+		return nil, err
+	}
+
+	return claims[len(claims)-1].lease, nil
+}
+
+// SwapLease replaces the prev lease by next, unless it was changed meanwhile.
+// Tags can't be written conditionally, but security groups names are unique
+// in a VPC: a new generation is claimed by creating a group named after it,
+// which only one writer can do. Renewals and releases tag the current
+// generation's group, and can't override a newer generation.
+func (h *Hoster) SwapLease(prev, next *lease.Lease) error {
+	claims, err := h.leaseClaims()
+	if err != nil {
+		return err
+	}
+
+	var current *leaseClaim
+	var currentLease *lease.Lease
+	if len(claims) > 0 {
+		current = claims[len(claims)-1]
+		currentLease = current.lease
+	}
+
+	if !lease.Equal(prev, currentLease) {
+		return lease.ErrConflict
+	}
+
+	h.log.Infof("Writing lease %s for %s\n", next.Encode(), h.conf.IP)
+
+	if h.conf.DryRun {
+		return nil
+	}
+
+	if current == nil || next.Generation != current.generation {
+		return h.claimLease(next, claims)
+	}
+
+	_, err = h.ec2s.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String(current.id)},
+		Tags: []*ec2.Tag{
+			&ec2.Tag{
+				Key:   aws.String(leaseTag),
+				Value: aws.String(next.Encode()),
+			},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidGroup.NotFound" {
+		// a newer generation was claimed meanwhile
+		return lease.ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to CreateTags: %v", err)
+	}
+
+	return nil
+}
+
+// claimLease creates the security group claiming next's generation, then
+// deletes the claims of previous generations
+func (h *Hoster) claimLease(next *lease.Lease, previous []*leaseClaim) error {
+	_, err := h.ec2s.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+		GroupName:   aws.String(h.leaseGroupName(next.Generation)),
+		Description: aws.String(encodeClaim(next)),
+		VpcId:       aws.String(h.vpc),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidGroup.Duplicate" {
+		return lease.ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to CreateSecurityGroup: %v", err)
+	}
+
+	for _, claim := range previous {
+		_, err = h.ec2s.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: aws.String(claim.id)})
+		if err != nil {
+			h.log.Warnf("Failed to delete the generation %d lease claim %s: %v\n",
+				claim.generation, claim.id, err)
+		}
+	}
+
+	return nil
+}
+
+// leaseClaims returns the security groups claiming the IP's lease generations,
+// lowest generation first
+func (h *Hoster) leaseClaims() ([]*leaseClaim, error) {
+	out, err := h.ec2s.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{
+			&ec2.Filter{
+				Name:   aws.String("vpc-id"),
+				Values: []*string{aws.String(h.vpc)},
+			},
+			&ec2.Filter{
+				Name:   aws.String("group-name"),
+				Values: []*string{aws.String(h.leaseGroupName(0) + "*")},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to DescribeSecurityGroups: %v", err)
+	}
+
+	var claims []*leaseClaim
+	for _, group := range out.SecurityGroups {
+		name := aws.StringValue(group.GroupName)
+		gen, err := strconv.ParseUint(strings.TrimPrefix(name, h.leaseGroupName(0)), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		claim := &leaseClaim{id: aws.StringValue(group.GroupId), generation: gen}
+		for _, tag := range group.Tags {
+			if aws.StringValue(tag.Key) == leaseTag {
+				claim.lease, err = lease.Decode(aws.StringValue(tag.Value))
+			}
+		}
+		if claim.lease == nil && err == nil {
+			claim.lease, err = decodeClaim(aws.StringValue(group.Description))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid lease on security group %s: %v", claim.id, err)
+		}
+
+		claim.lease.Generation = gen
+		claims = append(claims, claim)
+	}
+
+	sort.Slice(claims, func(i, j int) bool { return claims[i].generation < claims[j].generation })
+
+	return claims, nil
+}
+
+// leaseGroupName returns the name of the security group claiming a lease
+// generation (the names prefix when zero)
+func (h *Hoster) leaseGroupName(generation uint64) string {
+	name := leaseGroupPrefix + h.conf.IP + "-gen-"
+	if generation == 0 {
+		return name
+	}
+
+	return name + strconv.FormatUint(generation, 10)
+}
+
+// encodeClaim serializes a lease with the characters groups descriptions allow
+func encodeClaim(l *lease.Lease) string {
+	return fmt.Sprintf("cloud-floating-ip lease holder=%s expiry=%s",
+		l.Holder, l.Expiry.Format(time.RFC3339Nano))
+}
+
+// decodeClaim parses a lease serialized by encodeClaim
+func decodeClaim(s string) (*lease.Lease, error) {
+	l := &lease.Lease{}
+	for _, field := range strings.Fields(s) {
+		switch {
+		case strings.HasPrefix(field, "holder="):
+			l.Holder = strings.TrimPrefix(field, "holder=")
+		case strings.HasPrefix(field, "expiry="):
+			expiry, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(field, "expiry="))
+			if err != nil {
+				return nil, err
+			}
+			l.Expiry = expiry
+		}
+	}
+
+	if l.Holder == "" || l.Expiry.IsZero() {
+		return nil, fmt.Errorf("unexpected description '%s'", s)
+	}
+
+	return l, nil
+}
+
+// checkLease refuses to route the IP to instance while another one holds
+// the cloud election lease (unless forced). Routes can't be written
+// conditionally, so this is done right before each write.
+func (h *Hoster) checkLease(instance string) error {
+	if h.conf.Election != "cloud" || h.conf.Force {
+		return nil
+	}
+
+	l, err := h.GetLease()
+	if err != nil {
+		return err
+	}
+
+	return lease.Check(l, instance, time.Now())
+}
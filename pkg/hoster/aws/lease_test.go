@@ -0,0 +1,27 @@
+package aws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/lease"
+)
+
+func TestClaimDescription(t *testing.T) {
+	l := &lease.Lease{Holder: "i-0123456789abcdef0", Expiry: time.Now().Add(30 * time.Second)}
+
+	decoded, err := decodeClaim(encodeClaim(l))
+	if err != nil {
+		t.Fatalf("decodeClaim() failed: %v", err)
+	}
+
+	if !lease.Equal(l, decoded) {
+		t.Errorf("decodeClaim(encodeClaim()) = %+v, want %+v", decoded, l)
+	}
+
+	for _, desc := range []string{"", "cloud-floating-ip lease holder=i-1", "holder=i-1 expiry=soon"} {
+		if _, err := decodeClaim(desc); err == nil {
+			t.Errorf("decodeClaim(%q) should fail", desc)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/inventory"
+)
+
+// List returns all the /32 routes of our route tables, with their targets
+// resolved to instances IDs and Name tags
+func (h *Hoster) List() ([]*inventory.Entry, error) {
+	if err := h.refreshRouteTables(); err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, table := range h.routes {
+		ids = append(ids, aws.StringValue(table.RouteTableId))
+	}
+
+	list := inventory.NewList(ids)
+	for _, table := range h.routes {
+		for _, route := range table.Routes {
+			dest := aws.StringValue(route.DestinationCidrBlock)
+			if !strings.HasSuffix(dest, "/32") {
+				continue
+			}
+
+			list.Add(dest, aws.StringValue(table.RouteTableId), routeTarget(route))
+		}
+	}
+
+	if err := h.resolveTargets(list.Targets()); err != nil {
+		return nil, err
+	}
+
+	return list.Entries(), nil
+}
+
+// routeTarget returns the ID of whatever a route points to, flagging blackholes
+func routeTarget(route *ec2.Route) string {
+	target := routeTargetID(route)
+	if aws.StringValue(route.State) == blackhole {
+		target += " (blackhole)"
+	}
+
+	return target
+}
+
+// routeTargetID returns the ID of whatever a route points to
+func routeTargetID(route *ec2.Route) string {
+	for _, id := range []*string{route.NetworkInterfaceId, route.InstanceId,
+		route.GatewayId, route.NatGatewayId,
+		route.VpcPeeringConnectionId, route.EgressOnlyInternetGatewayId} {
+		if aws.StringValue(id) != "" {
+			return *id
+		}
+	}
+
+	return "unknown"
+}
+
+// resolveTargets fills the instance and Name tag of ENIs and instances targets
+func (h *Hoster) resolveTargets(targets []*inventory.Target) error {
+	var ids []string
+	for _, t := range targets {
+		ids = append(ids, strings.Fields(t.ID)[0])
+	}
+
+	instances, names, err := h.resolveInstances(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		t.Instance = instances[strings.Fields(t.ID)[0]]
+		t.Name = names[t.Instance]
+	}
+
+	return nil
+}
+
+// resolveInstances maps ENIs and instances IDs (other targets are ignored) to
+// the instance they are (or are attached to), and instances to their Name tag
+func (h *Hoster) resolveInstances(ids []string) (map[string]string, map[string]string, error) {
+	instances := make(map[string]string)
+	names := make(map[string]string)
+
+	var enis []*string
+	for _, id := range ids {
+		switch {
+		case strings.HasPrefix(id, "i-"):
+			instances[id] = id
+		case strings.HasPrefix(id, "eni-"):
+			enis = append(enis, aws.String(id))
+		}
+	}
+
+	// filters (unlike IDs) don't fail on deleted ENIs or instances
+	if len(enis) > 0 {
+		out, err := h.ec2s.DescribeNetworkInterfaces(&ec2.DescribeNetworkInterfacesInput{
+			Filters: []*ec2.Filter{&ec2.Filter{Name: aws.String("network-interface-id"), Values: enis}},
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to DescribeNetworkInterfaces: %v", err)
+		}
+
+		for _, iface := range out.NetworkInterfaces {
+			if iface.Attachment != nil && iface.Attachment.InstanceId != nil {
+				instances[aws.StringValue(iface.NetworkInterfaceId)] = *iface.Attachment.InstanceId
+			}
+		}
+	}
+
+	if len(instances) == 0 {
+		return instances, names, nil
+	}
+
+	var ilist []*string
+	for _, instance := range instances {
+		ilist = append(ilist, aws.String(instance))
+	}
+
+	err := h.ec2s.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{&ec2.Filter{Name: aws.String("instance-id"), Values: ilist}},
+	}, func(out *ec2.DescribeInstancesOutput, last bool) bool {
+		for _, res := range out.Reservations {
+			for _, inst := range res.Instances {
+				for _, tag := range inst.Tags {
+					if aws.StringValue(tag.Key) == "Name" {
+						names[aws.StringValue(inst.InstanceId)] = aws.StringValue(tag.Value)
+					}
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to DescribeInstances: %v", err)
+	}
+
+	return instances, names, nil
+}
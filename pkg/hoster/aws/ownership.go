@@ -0,0 +1,113 @@
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/ownership"
+)
+
+// Ownership returns the state of the route to the IP in each of our route
+// tables and the instance it targets, and the instance (and Name tag) the
+// first route targets. AWS routes have no modification date.
+func (h *Hoster) Ownership() (*ownership.Ownership, error) {
+	if err := h.refreshRouteTables(); err != nil {
+		return nil, err
+	}
+
+	o := &ownership.Ownership{
+		IP:      h.conf.IP,
+		Network: h.vpc,
+	}
+
+	var targets []string
+
+	for _, table := range h.routes {
+		t := ownership.Table{ID: aws.StringValue(table.RouteTableId)}
+
+		status, route := isRouteInTable(table, h.cidr, h.enid, h.conf.Instance)
+		switch {
+		case status == rsAbsent:
+			t.State = ownership.StateAbsent
+		case aws.StringValue(route.State) == blackhole:
+			t.State = ownership.StateBlackhole
+		case status == rsCorrectTarget:
+			t.State = ownership.StateUs
+		default:
+			t.State = ownership.StateForeign
+		}
+
+		if route != nil {
+			t.Target = routeTargetID(route)
+			targets = append(targets, t.Target)
+		}
+
+		if o.Target == "" && t.State != ownership.StateAbsent {
+			o.Target = t.Target
+		}
+
+		o.Tables = append(o.Tables, t)
+	}
+
+	if len(targets) == 0 {
+		return o, nil
+	}
+
+	instances, names, err := h.resolveInstances(targets)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range o.Tables {
+		o.Tables[i].Owner = instances[o.Tables[i].Target]
+	}
+
+	o.Owner = instances[o.Target]
+	o.Name = names[o.Owner]
+
+	return o, nil
+}
+
+// expectOwner re-reads table, and returns a *ownership.MismatchError when its
+// route to the IP targets another owner than the expected one. Routes can't
+// be written conditionally, so this is done right before each write. Only
+// ownership.OwnerNone matches an absent route.
+func (h *Hoster) expectOwner(table *ec2.RouteTable) error {
+	if h.conf.ExpectOwner == "" {
+		return nil
+	}
+
+	current, err := h.currentTable(table)
+	if err != nil {
+		return err
+	}
+
+	var instance, target string
+	if _, route := isRouteInTable(current, h.cidr, nil, ""); route != nil {
+		instance, target = aws.StringValue(route.InstanceId), routeTargetID(route)
+	}
+
+	if ownership.Matches(h.conf.ExpectOwner, instance, target) {
+		return nil
+	}
+
+	return ownership.Mismatch(aws.StringValue(table.RouteTableId), h.conf.IP, target, h.conf.ExpectOwner)
+}
+
+// currentTable re-reads table
+func (h *Hoster) currentTable(table *ec2.RouteTable) (*ec2.RouteTable, error) {
+	out, err := h.ec2s.DescribeRouteTables(&ec2.DescribeRouteTablesInput{
+		RouteTableIds: []*string{table.RouteTableId},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to DescribeRouteTables: %v", err)
+	}
+
+	if len(out.RouteTables) == 0 {
+		return nil, fmt.Errorf("route table %s not found", aws.StringValue(table.RouteTableId))
+	}
+
+	return out.RouteTables[0], nil
+}
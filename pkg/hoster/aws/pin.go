@@ -0,0 +1,111 @@
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/pin"
+)
+
+const pinTagPrefix = "cloud-floating-ip-pin-for-"
+
+// GetPin returns the pin stored in our route tables tags (nil when there's
+// none, or when we aren't allowed to read tags: pinning is then unused)
+func (h *Hoster) GetPin() (*pin.Pin, error) {
+	out, err := h.ec2s.DescribeTags(&ec2.DescribeTagsInput{
+		Filters: []*ec2.Filter{
+			&ec2.Filter{
+				Name:   aws.String("resource-id"),
+				Values: h.tableIds(),
+			},
+			&ec2.Filter{
+				Name:   aws.String("key"),
+				Values: []*string{aws.String(pinTagPrefix + h.conf.IP)},
+			},
+		},
+	})
+	if denied(err) {
+		if !h.pinDenied {
+			h.log.Warnf("Not allowed to read pins, assuming %s isn't pinned: %v\n", h.conf.IP, err)
+			h.pinDenied = true
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to DescribeTags: %v", err)
+	}
+
+	// tables may disagree after a partial write: the latest pin wins
+	var current *pin.Pin
+	for _, tag := range out.Tags {
+		if tag.Value == nil {
+			continue
+		}
+
+		p, err := pin.Decode(*tag.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pin on table %s: %v", *tag.ResourceId, err)
+		}
+
+		if current == nil || p.Since.After(current.Since) {
+			current = p
+		}
+	}
+
+	return current, nil
+}
+
+// SetPin writes the pin on all our route tables, or removes it when p is nil
+func (h *Hoster) SetPin(p *pin.Pin) error {
+	key := aws.String(pinTagPrefix + h.conf.IP)
+
+	if p == nil {
+		h.log.Infof("Removing pin from %d route table(s)\n", len(h.routes))
+	} else {
+		h.log.Infof("Writing pin %s on %d route table(s)\n", p.Encode(), len(h.routes))
+	}
+
+	if h.conf.DryRun {
+		return nil
+	}
+
+	if p == nil {
+		_, err := h.ec2s.DeleteTags(&ec2.DeleteTagsInput{
+			Resources: h.tableIds(),
+			Tags:      []*ec2.Tag{&ec2.Tag{Key: key}},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to DeleteTags: %v", err)
+		}
+
+		return nil
+	}
+
+	_, err := h.ec2s.CreateTags(&ec2.CreateTagsInput{
+		Resources: h.tableIds(),
+		Tags:      []*ec2.Tag{&ec2.Tag{Key: key, Value: aws.String(p.Encode())}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to CreateTags: %v", err)
+	}
+
+	return nil
+}
+
+// denied returns true when err is an authorization failure
+func denied(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+
+	switch aerr.Code() {
+	case "UnauthorizedOperation", "AccessDenied", "AccessDeniedException":
+		return true
+	}
+
+	return false
+}
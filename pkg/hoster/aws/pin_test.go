@@ -0,0 +1,27 @@
+package aws
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestDenied(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("UnauthorizedOperation"), false},
+		{awserr.New("UnauthorizedOperation", "not allowed", nil), true},
+		{awserr.New("AccessDenied", "not allowed", nil), true},
+		{awserr.New("RequestLimitExceeded", "slow down", nil), false},
+	}
+
+	for _, tt := range tests {
+		if got := denied(tt.err); got != tt.want {
+			t.Errorf("denied(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/plan"
+)
+
+// Plan returns the changes to the routes to the IP that a preempt (or
+// destroy) would make in our route tables, without making them. Fencing
+// isn't planned.
+func (h *Hoster) Plan(destroy bool) (*plan.Plan, error) {
+	if err := h.refreshRouteTables(); err != nil {
+		return nil, err
+	}
+
+	p := &plan.Plan{
+		IP:        h.conf.IP,
+		Instance:  h.conf.Instance,
+		Operation: plan.OperationPreempt,
+	}
+	if destroy {
+		p.Operation = plan.OperationDestroy
+	}
+
+	for _, table := range h.routes {
+		c := plan.Change{Table: aws.StringValue(table.RouteTableId)}
+
+		status, route := isRouteInTable(table, h.cidr, h.enid, h.conf.Instance)
+		if route != nil {
+			c.Before = routeTargetID(route)
+		}
+
+		switch {
+		case destroy && (status == rsCorrectTarget || (status == rsWrongTarget && h.conf.DestroyAll)):
+			c.Action = plan.ActionDelete
+		case destroy || status == rsCorrectTarget:
+			continue
+		case status == rsAbsent:
+			c.Action, c.After = plan.ActionCreate, *h.enid
+		case status == rsWrongTarget:
+			c.Action, c.After = plan.ActionReplace, *h.enid
+		}
+
+		p.Changes = append(p.Changes, c)
+	}
+
+	return p, nil
+}
+
+// Apply makes the planned changes to our route tables, unless their routes
+// to the IP changed since the plan was computed. All the tables are checked
+// first, then each one again right before its write.
+func (h *Hoster) Apply(p *plan.Plan) error {
+	if err := h.refreshRouteTables(); err != nil {
+		return err
+	}
+
+	tables := make(map[string]*ec2.RouteTable)
+	for _, table := range h.routes {
+		tables[aws.StringValue(table.RouteTableId)] = table
+	}
+
+	for _, c := range p.Changes {
+		table, ok := tables[c.Table]
+		if !ok {
+			return fmt.Errorf("the plan changes the %s table, not one of ours", c.Table)
+		}
+
+		if c.Action != plan.ActionDelete && !strings.HasPrefix(c.After, "eni-") {
+			return fmt.Errorf("can't route %s to %s: not a network interface", h.conf.IP, c.After)
+		}
+
+		if err := h.checkChange(table, c); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range p.Changes {
+		table := tables[c.Table]
+
+		current, err := h.currentTable(table)
+		if err != nil {
+			return err
+		}
+
+		if err = h.checkChange(current, c); err != nil {
+			return err
+		}
+
+		switch c.Action {
+		case plan.ActionCreate:
+			err = h.addRouteInTable(table, h.cidr, aws.String(c.After), h.conf.Instance)
+		case plan.ActionReplace:
+			err = h.replaceRouteInTable(table, h.cidr, aws.String(c.After), h.conf.Instance)
+		case plan.ActionDelete:
+			err = h.deleteRouteInTable(table, h.cidr)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkChange returns a *plan.StaleError unless the route to the IP in table
+// still targets what the change was planned from
+func (h *Hoster) checkChange(table *ec2.RouteTable, c plan.Change) error {
+	current := ""
+	if _, route := isRouteInTable(table, h.cidr, nil, ""); route != nil {
+		current = routeTargetID(route)
+	}
+
+	if current == c.Before {
+		return nil
+	}
+
+	return &plan.StaleError{Reason: fmt.Sprintf("%s now routes %s to '%s', planned from '%s'",
+		c.Table, h.conf.IP, current, c.Before)}
+}
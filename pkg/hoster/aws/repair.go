@@ -0,0 +1,67 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/ownership"
+	"github.com/bpineau/cloud-floating-ip/pkg/pin"
+)
+
+// Repair routes the IP to the majority target (see ownership.Majority) in
+// all our route tables, unless a pin or the cloud election lease forbids
+// that target's instance to own the IP
+func (h *Hoster) Repair() error {
+	o, err := h.Ownership()
+	if err != nil {
+		return err
+	}
+
+	target := o.Majority()
+	if target == "" {
+		return fmt.Errorf("no route to %s to repair", h.conf.IP)
+	}
+
+	for _, t := range o.Tables {
+		if t.Target == target && t.State == ownership.StateBlackhole {
+			return fmt.Errorf("routes to %s only target %s, which is gone", h.conf.IP, target)
+		}
+	}
+
+	if !strings.HasPrefix(target, "eni-") {
+		return fmt.Errorf("can't repair routes to %s: not a network interface", target)
+	}
+
+	instances, _, err := h.resolveInstances([]string{target})
+	if err != nil {
+		return err
+	}
+
+	// the pin and lease are checked for the target's instance, not ours
+	instance := instances[target]
+	if err = pin.Verify(h, instance, h.conf.Force); err != nil {
+		return err
+	}
+
+	h.log.Infof("Repairing %s routes to %s\n", h.conf.IP, target)
+
+	eni := aws.String(target)
+	for _, table := range h.routes {
+		status, _ := isRouteInTable(table, h.cidr, eni, "")
+
+		switch status {
+		case rsAbsent:
+			err = h.addRouteInTable(table, h.cidr, eni, instance)
+		case rsWrongTarget:
+			err = h.replaceRouteInTable(table, h.cidr, eni, instance)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package gce
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	fenceStop = "stop"
+
+	defaultFenceTimeout = 2 * time.Minute
+)
+
+func (h *Hoster) checkFenceActions() error {
+	for _, action := range h.conf.Fence {
+		if action != fenceStop {
+			return fmt.Errorf("unsupported fence action on gce: '%s'", action)
+		}
+	}
+
+	return nil
+}
+
+// Fence makes sure the instance our route currently targets can't serve
+// the IP anymore, before we take over. Fails before fencing it when it isn't
+// the expected owner.
+func (h *Hoster) Fence() error {
+	if len(h.conf.Fence) == 0 {
+		return nil
+	}
+
+	timeout := h.conf.FenceTimeout
+	if timeout <= 0 {
+		timeout = defaultFenceTimeout
+	}
+
+	if err := h.expectOwner(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(*h.ctx, timeout)
+	defer cancel()
+
+	route, err := h.svc.Routes.Get(h.conf.Project, h.rname).Context(ctx).Do()
+	if apierr, ok := err.(*googleapi.Error); ok && apierr.Code == 404 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get route: %v", err)
+	}
+
+	if route.NextHopInstance == "" || route.NextHopInstance == h.selflink {
+		return nil
+	}
+
+	return h.stopInstance(ctx, route.NextHopInstance)
+}
+
+// stopInstance stops the instance given by its selflink, and waits until it's stopped
+func (h *Hoster) stopInstance(ctx context.Context, selflink string) error {
+	project, zone, instance, err := parseSelfLink(selflink)
+	if err != nil {
+		return err
+	}
+
+	h.log.Infof("Fencing: stopping instance %s in %s zone\n", instance, zone)
+
+	if h.conf.DryRun {
+		return nil
+	}
+
+	op, err := h.svc.Instances.Stop(project, zone, instance).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to stop %s: %v", instance, err)
+	}
+
+	return h.zonalWait(ctx, project, zone, op)
+}
+
+// zonalWait waits for a zonal operation to finish, until ctx expires
+func (h *Hoster) zonalWait(ctx context.Context, project, zone string, op *compute.Operation) error {
+	for {
+		operation, err := h.svc.ZoneOperations.Get(project, zone, op.Name).Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+
+		if operation.Error != nil {
+			return fmt.Errorf("operation failed: %v", operation.Error)
+		}
+
+		if operation.Status == "DONE" {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout waiting for %s to finish", op.Name)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// parseSelfLink returns the project, zone and name of an instance selflink
+func parseSelfLink(selflink string) (string, string, string, error) {
+	var project, zone, instance string
+
+	parts := strings.Split(selflink, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		switch parts[i] {
+		case "projects":
+			project = parts[i+1]
+		case "zones":
+			zone = parts[i+1]
+		case "instances":
+			instance = parts[i+1]
+		}
+	}
+
+	if project == "" || zone == "" || instance == "" {
+		return "", "", "", fmt.Errorf("invalid instance selflink: '%s'", selflink)
+	}
+
+	return project, zone, instance, nil
+}
@@ -15,6 +15,8 @@ import (
 
 	"github.com/bpineau/cloud-floating-ip/config"
 	"github.com/bpineau/cloud-floating-ip/pkg/log"
+	"github.com/bpineau/cloud-floating-ip/pkg/ownership"
+	"github.com/bpineau/cloud-floating-ip/pkg/pin"
 
 	"cloud.google.com/go/compute/metadata"
 	"golang.org/x/oauth2/google"
@@ -25,6 +27,16 @@ import (
 const (
 	instanceSelfLink = `https://www.googleapis.com/compute/v1/projects/%s/zones/%s/instances/%s`
 	routePrefix      = `cloud-floating-ip-rule-for-`
+	leasePrefix      = `cloud-floating-ip-lease-for-`
+	pinPrefix        = `cloud-floating-ip-pin-for-`
+	defaultGateway   = `projects/%s/global/gateways/default-internet-gateway`
+
+	// records routes (lease, pin) only apply to instances with this network
+	// tag, which none should have: they never route traffic
+	recordTag = `cloud-floating-ip-record`
+
+	// records routes have the lowest priority, so they don't shadow the main route
+	recordPriority = 65535
 )
 
 // Hoster represents an hosting provider (here, gce)
@@ -36,11 +48,15 @@ type Hoster struct {
 	log      log.Logger
 	network  string
 	rname    string
+	lname    string
+	pname    string
 	selflink string
+	owner    bool
+	routeID  uint64
 }
 
 // Init prepare a gce hoster for usage
-func (h *Hoster) Init(conf *config.CfiConfig, logger log.Logger) {
+func (h *Hoster) Init(conf *config.CfiConfig, logger log.Logger) error {
 	var err error
 	ctx := context.Background()
 	h.conf = conf
@@ -48,42 +64,52 @@ func (h *Hoster) Init(conf *config.CfiConfig, logger log.Logger) {
 
 	err = h.checkMissingParam()
 	if err != nil {
-		h.log.Fatalf("Missing parameter: %v\n", err)
+		return fmt.Errorf("missing parameter: %v", err)
 	}
 
-	h.conf.Project, err = h.getProject()
-	if err != nil {
-		h.log.Fatalf("Failed to guess project id: %v\n", err)
+	if err = h.checkFenceActions(); err != nil {
+		return fmt.Errorf("invalid fencing: %v", err)
 	}
 
-	h.conf.Instance, err = h.getInstance()
+	h.conf.Project, err = h.getProject()
 	if err != nil {
-		h.log.Fatalf("Failed to guess instance id: %v\n", err)
+		return fmt.Errorf("failed to guess project id: %v", err)
 	}
 
-	h.conf.Zone, err = h.getZone()
+	given := h.conf.Instance != ""
+	h.conf.Instance, err = h.getInstance()
 	if err != nil {
-		h.log.Fatalf("Failed to guess instance zone: %v\n", err)
+		return fmt.Errorf("failed to guess instance id: %v", err)
 	}
 
-	h.rname = strings.Replace(routePrefix+h.conf.IP, ".", "-", -1)
-	h.selflink = fmt.Sprintf(instanceSelfLink, h.conf.Project, h.conf.Zone, h.conf.Instance)
 	h.ctx = &ctx
 
 	h.client, err = google.DefaultClient(*h.ctx, compute.CloudPlatformScope)
 	if err != nil {
-		h.log.Fatalf("Failed to get default client %s\n", err)
+		return fmt.Errorf("failed to get default client: %v", err)
 	}
 
 	h.svc, err = compute.New(h.client)
 	if err != nil {
-		h.log.Fatalf("Failed to instantiate a compute client: %s\n", err)
+		return fmt.Errorf("failed to instantiate a compute client: %v", err)
+	}
+
+	h.conf.Zone, err = h.getZone(given)
+	if err != nil {
+		return fmt.Errorf("failed to guess instance zone: %v", err)
 	}
 
+	h.rname = strings.Replace(routePrefix+h.conf.IP, ".", "-", -1)
+	h.lname = strings.Replace(leasePrefix+h.conf.IP, ".", "-", -1)
+	h.pname = strings.Replace(pinPrefix+h.conf.IP, ".", "-", -1)
+	h.selflink = fmt.Sprintf(instanceSelfLink, h.conf.Project, h.conf.Zone, h.conf.Instance)
+
 	h.network, err = h.getNetwork()
 	if err != nil {
-		h.log.Fatalf("Failed to collect network infos: %v\n", err)
+		return fmt.Errorf("failed to collect network infos: %v", err)
 	}
+
+	return nil
 }
 
 func (h *Hoster) getProject() (string, error) {
@@ -102,12 +128,57 @@ func (h *Hoster) getInstance() (string, error) {
 	return metadata.InstanceName()
 }
 
-func (h *Hoster) getZone() (string, error) {
+// getZone returns the target instance's zone. When the instance was given
+// (it may be another one than ours), that's our zone if it's found there, or
+// else the zone the API finds it in.
+func (h *Hoster) getZone(given bool) (string, error) {
 	if h.conf.Zone != "" {
 		return h.conf.Zone, nil
 	}
 
-	return metadata.Zone()
+	zone, err := metadata.Zone()
+	if !given {
+		return zone, err
+	}
+
+	if err == nil {
+		_, err = h.svc.Instances.Get(h.conf.Project, zone, h.conf.Instance).Context(*h.ctx).Do()
+		if err == nil {
+			return zone, nil
+		}
+		if apierr, ok := err.(*googleapi.Error); !ok || apierr.Code != 404 {
+			return "", fmt.Errorf("failed to get instance %s: %v", h.conf.Instance, err)
+		}
+	}
+
+	return h.findZone()
+}
+
+// findZone returns the zone holding the target instance, in the whole project
+func (h *Hoster) findZone() (string, error) {
+	var zones []string
+
+	call := h.svc.Instances.AggregatedList(h.conf.Project).Filter(fmt.Sprintf("name = %s", h.conf.Instance))
+	err := call.Pages(*h.ctx, func(list *compute.InstanceAggregatedList) error {
+		for scope, items := range list.Items {
+			if len(items.Instances) > 0 {
+				zones = append(zones, strings.TrimPrefix(scope, "zones/"))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list instances: %v", err)
+	}
+
+	switch len(zones) {
+	case 0:
+		return "", fmt.Errorf("instance %s not found", h.conf.Instance)
+	case 1:
+		return zones[0], nil
+	}
+
+	return "", fmt.Errorf("instance %s found in several zones (%s), use --zone", h.conf.Instance, strings.Join(zones, ", "))
 }
 
 func (h *Hoster) getNetwork() (string, error) {
@@ -183,58 +254,151 @@ func (h *Hoster) Preempt() error {
 		return nil
 	}
 
+	if err := pin.Verify(h, h.conf.Instance, h.conf.Force); err != nil {
+		return err
+	}
+
 	h.log.Infof("Preempting %s route(s)\n", h.conf.IP)
 
+	// There's no "update" or "replace" in GCP routes API.
+	route, err := h.currentRoute()
+	if err != nil {
+		return err
+	}
+
+	if route != nil {
+		err = h.deleteRoute()
+	} else {
+		err = h.expectOwner()
+	}
+	if err != nil {
+		return err
+	}
+
+	return h.insertRoute(h.selflink)
+}
+
+// insertRoute creates the route to the IP, via the instance
+func (h *Hoster) insertRoute(instance string) error {
+	if err := h.checkLease(); err != nil {
+		return err
+	}
+
 	rb := &compute.Route{
 		Name:            h.rname,
-		NextHopInstance: h.selflink,
+		NextHopInstance: instance,
 		Network:         h.network,
 		DestRange:       h.conf.IP,
 	}
 
-	// There's no "update" or "replace" in GCP routes API.
-	err := h.Destroy()
-	if err != nil {
-		h.log.Fatalf("Failed to delete the route: %v\n", err)
-	}
-
 	h.log.Infof("Creating a route %s to %s via %s on %s network\n",
-		h.rname, h.conf.IP, h.selflink, h.network)
+		h.rname, h.conf.IP, instance, h.network)
 
 	if h.conf.DryRun {
 		return nil
 	}
 
-	err = h.blockingWait(h.svc.Routes.Insert(h.conf.Project, rb).Do())
+	err := h.blockingWait(h.svc.Routes.Insert(h.conf.Project, rb).Do())
+	if apierr, ok := err.(*googleapi.Error); ok && apierr.Code == 409 && h.conf.ExpectOwner != "" {
+		return &ownership.MismatchError{Reason: fmt.Sprintf("route %s was created meanwhile", h.rname)}
+	}
 	if err != nil {
-		h.log.Fatalf("Failed to create the route: %v\n", err)
+		return fmt.Errorf("failed to create the route: %v", err)
 	}
 
 	return nil
 }
 
-// Status returns true if the floating IP address route to the instance
-func (h *Hoster) Status() bool {
-	resp, err := h.svc.Routes.Get(h.conf.Project, h.rname).Context(*h.ctx).Do()
+// recordRoute returns a route storing a record (lease or pin) in its
+// description. It applies to no instance (see recordTag), so it doesn't
+// route the IP anywhere.
+func (h *Hoster) recordRoute(name, description string) *compute.Route {
+	return &compute.Route{
+		Name:           name,
+		NextHopGateway: fmt.Sprintf(defaultGateway, h.conf.Project),
+		Network:        h.network,
+		DestRange:      h.conf.IP,
+		Priority:       recordPriority,
+		Tags:           []string{recordTag},
+		Description:    description,
+	}
+}
 
-	if err == nil {
-		return resp.NextHopInstance == h.selflink
+// Status returns true if the floating IP address route to the instance. On
+// API errors, the last known status is returned.
+func (h *Hoster) Status() bool {
+	route, err := h.currentRoute()
+	if err != nil {
+		h.log.Errorf("Failed to get route status, using last known state: %v\n", err)
+		return h.owner
 	}
 
 	// route not found is ok, means we don't "own" the IP
-	if apierr, ok := err.(*googleapi.Error); ok {
-		if apierr.Code == 404 {
-			return false
-		}
+	h.owner = route != nil && route.NextHopInstance == h.selflink
+	h.remember(route)
+
+	return h.owner
+}
+
+// Owner returns the next hop instance of the route to the IP, or an empty
+// string when nobody owns the IP
+func (h *Hoster) Owner() (string, error) {
+	resp, err := h.svc.Routes.Get(h.conf.Project, h.rname).Context(*h.ctx).Do()
+	if err == nil {
+		return resp.NextHopInstance, nil
 	}
 
-	h.log.Fatalf("Failed to get route status: %v\n", err)
+	if apierr, ok := err.(*googleapi.Error); ok && apierr.Code == 404 {
+		return "", nil
+	}
 
-	return false
+	return "", fmt.Errorf("failed to get route: %v", err)
 }
 
-// Destroy remove route to the IP from our VPC
+// Destroy removes the route to the IP from our network when it targets the
+// instance (whatever its target with DestroyAll). A foreign route is reported.
+// The route is read again right before deletion, and left untouched when it
+// was replaced since last seen (by Status or Ownership).
 func (h *Hoster) Destroy() error {
+	if h.conf.DestroyAll {
+		return h.deleteRoute()
+	}
+
+	seen := h.routeID
+
+	route, err := h.currentRoute()
+	if err != nil || route == nil {
+		return err
+	}
+
+	if route.NextHopInstance != h.selflink {
+		h.log.Warnf("Not deleting route %s to %s, targeting %s\n", h.rname, h.conf.IP, nextHop(route))
+		return nil
+	}
+
+	if seen != 0 && route.Id != seen {
+		h.log.Warnf("Not deleting route %s to %s, changed meanwhile\n", h.rname, h.conf.IP)
+		return nil
+	}
+
+	return h.deleteRoute()
+}
+
+// remember records the route to the IP as last seen (nil when absent). Routes
+// can't be updated, so a route with the same ID didn't change.
+func (h *Hoster) remember(route *compute.Route) {
+	h.routeID = 0
+	if route != nil {
+		h.routeID = route.Id
+	}
+}
+
+// deleteRoute deletes the route to the IP, whatever its target
+func (h *Hoster) deleteRoute() error {
+	if err := h.expectOwner(); err != nil {
+		return err
+	}
+
 	h.log.Infof("Deleting route to %s from %s network\n", h.conf.IP, h.network)
 
 	if h.conf.DryRun {
@@ -0,0 +1,32 @@
+package gce
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/compute/metadata"
+	"google.golang.org/api/googleapi"
+)
+
+// LocalInstance returns the name of the instance we run on, from its metadata
+func (h *Hoster) LocalInstance() (string, error) {
+	return metadata.InstanceName()
+}
+
+// InstanceHealthy returns true when the instance, given by its selflink (as
+// returned by Owner), is running
+func (h *Hoster) InstanceHealthy(instance string) (bool, error) {
+	project, zone, name, err := parseSelfLink(instance)
+	if err != nil {
+		return false, err
+	}
+
+	inst, err := h.svc.Instances.Get(project, zone, name).Context(*h.ctx).Do()
+	if apierr, ok := err.(*googleapi.Error); ok && apierr.Code == 404 {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get instance %s: %v", name, err)
+	}
+
+	return inst.Status == "RUNNING", nil
+}
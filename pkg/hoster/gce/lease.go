@@ -0,0 +1,94 @@
+package gce
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/lease"
+)
+
+// GetLease returns the lease stored in the lease route description (nil when there's none)
+func (h *Hoster) GetLease() (*lease.Lease, error) {
+	resp, err := h.svc.Routes.Get(h.conf.Project, h.lname).Context(*h.ctx).Do()
+	if err != nil {
+		if apierr, ok := err.(*googleapi.Error); ok && apierr.Code == 404 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get the lease route: %v", err)
+	}
+
+	l, err := lease.Decode(resp.Description)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lease on route %s: %v", h.lname, err)
+	}
+
+	return l, nil
+}
+
+// SwapLease replaces the prev lease by next, unless it was changed meanwhile.
+// The lease is the description of a record route, that doesn't route traffic
+// (see recordRoute). Routes can't be updated, so we delete then insert it: a
+// concurrent writer makes either call fail (404 or 409), or is caught when
+// reading the lease back.
+func (h *Hoster) SwapLease(prev, next *lease.Lease) error {
+	current, err := h.GetLease()
+	if err != nil {
+		return err
+	}
+
+	if !lease.Equal(prev, current) {
+		return lease.ErrConflict
+	}
+
+	h.log.Infof("Writing lease %s on route %s\n", next.Encode(), h.lname)
+
+	if h.conf.DryRun {
+		return nil
+	}
+
+	if current != nil {
+		err = h.blockingWait(h.svc.Routes.Delete(h.conf.Project, h.lname).Do())
+		if apierr, ok := err.(*googleapi.Error); ok && apierr.Code == 404 {
+			return lease.ErrConflict
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete the lease route: %v", err)
+		}
+	}
+
+	err = h.blockingWait(h.svc.Routes.Insert(h.conf.Project, h.recordRoute(h.lname, next.Encode())).Do())
+	if apierr, ok := err.(*googleapi.Error); ok && apierr.Code == 409 {
+		return lease.ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create the lease route: %v", err)
+	}
+
+	current, err = h.GetLease()
+	if err != nil {
+		return err
+	}
+
+	if !lease.Equal(next, current) {
+		return lease.ErrConflict
+	}
+
+	return nil
+}
+
+// checkLease refuses to route the IP to the instance while another one holds
+// the cloud election lease (unless forced)
+func (h *Hoster) checkLease() error {
+	if h.conf.Election != "cloud" || h.conf.Force {
+		return nil
+	}
+
+	l, err := h.GetLease()
+	if err != nil {
+		return err
+	}
+
+	return lease.Check(l, h.conf.Instance, time.Now())
+}
@@ -0,0 +1,52 @@
+package gce
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/inventory"
+)
+
+// List returns all the floating IPs routes (named with routePrefix) of our network
+func (h *Hoster) List() ([]*inventory.Entry, error) {
+	network := path.Base(h.network)
+	list := inventory.NewList([]string{network})
+
+	err := h.svc.Routes.List(h.conf.Project).Pages(*h.ctx, func(page *compute.RouteList) error {
+		for _, route := range page.Items {
+			if !strings.HasPrefix(route.Name, routePrefix) || route.Network != h.network {
+				continue
+			}
+
+			list.Add(route.DestRange, network, nextHop(route))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %v", err)
+	}
+
+	for _, t := range list.Targets() {
+		if _, zone, name, err := parseSelfLink(t.ID); err == nil {
+			t.ID = path.Join(zone, name)
+			t.Instance = name
+		}
+	}
+
+	return list.Entries(), nil
+}
+
+// nextHop returns the next hop of a route
+func nextHop(route *compute.Route) string {
+	for _, hop := range []string{route.NextHopInstance, route.NextHopIp,
+		route.NextHopGateway, route.NextHopNetwork} {
+		if hop != "" {
+			return hop
+		}
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,99 @@
+package gce
+
+import (
+	"path"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/ownership"
+)
+
+// Ownership returns the state of the route to the IP in our network, and the
+// instance it targets. The route's creation date is its last modification,
+// as routes can't be updated.
+func (h *Hoster) Ownership() (*ownership.Ownership, error) {
+	network := path.Base(h.network)
+	o := &ownership.Ownership{
+		IP:      h.conf.IP,
+		Network: network,
+	}
+
+	t := ownership.Table{ID: network, State: ownership.StateAbsent}
+
+	route, err := h.currentRoute()
+	if err != nil {
+		return nil, err
+	}
+
+	h.remember(route)
+	if route == nil {
+		o.Tables = []ownership.Table{t}
+		return o, nil
+	}
+
+	t.Target = nextHop(route)
+	o.Target = t.Target
+
+	switch {
+	case unreachable(route):
+		t.State = ownership.StateBlackhole
+	case route.NextHopInstance == h.selflink:
+		t.State = ownership.StateUs
+	default:
+		t.State = ownership.StateForeign
+	}
+
+	if _, _, name, err := parseSelfLink(route.NextHopInstance); err == nil {
+		t.Owner = name
+		o.Owner = name
+	}
+
+	if created, err := time.Parse(time.RFC3339, route.CreationTimestamp); err == nil {
+		o.Modified = &created
+	}
+
+	o.Tables = []ownership.Table{t}
+
+	return o, nil
+}
+
+// unreachable returns true when the route's next hop can't forward traffic
+func unreachable(route *compute.Route) bool {
+	for _, w := range route.Warnings {
+		if w.Code == "NEXT_HOP_INSTANCE_NOT_FOUND" || w.Code == "NEXT_HOP_NOT_RUNNING" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expectOwner returns a *ownership.MismatchError when the route to the IP
+// targets another owner than the expected one. Routes can't be written
+// conditionally, so this is done right before. Only ownership.OwnerNone
+// matches an absent route.
+func (h *Hoster) expectOwner() error {
+	if h.conf.ExpectOwner == "" {
+		return nil
+	}
+
+	route, err := h.currentRoute()
+	if err != nil {
+		return err
+	}
+
+	var name, target string
+	if route != nil {
+		target = nextHop(route)
+		if _, _, n, err := parseSelfLink(route.NextHopInstance); err == nil {
+			name = n
+		}
+	}
+
+	if ownership.Matches(h.conf.ExpectOwner, name, target) {
+		return nil
+	}
+
+	return ownership.Mismatch(h.rname, h.conf.IP, target, h.conf.ExpectOwner)
+}
@@ -0,0 +1,59 @@
+package gce
+
+import (
+	"fmt"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/pin"
+)
+
+// GetPin returns the pin stored in the pin route description (nil when there's none)
+func (h *Hoster) GetPin() (*pin.Pin, error) {
+	resp, err := h.svc.Routes.Get(h.conf.Project, h.pname).Context(*h.ctx).Do()
+	if err != nil {
+		if apierr, ok := err.(*googleapi.Error); ok && apierr.Code == 404 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get the pin route: %v", err)
+	}
+
+	p, err := pin.Decode(resp.Description)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pin on route %s: %v", h.pname, err)
+	}
+
+	return p, nil
+}
+
+// SetPin replaces the pin, or removes it when p is nil. Like the lease, the
+// pin is the description of a record route, that doesn't route traffic.
+func (h *Hoster) SetPin(p *pin.Pin) error {
+	if p == nil {
+		h.log.Infof("Removing pin route %s\n", h.pname)
+	} else {
+		h.log.Infof("Writing pin %s on route %s\n", p.Encode(), h.pname)
+	}
+
+	if h.conf.DryRun {
+		return nil
+	}
+
+	err := h.blockingWait(h.svc.Routes.Delete(h.conf.Project, h.pname).Do())
+	if apierr, ok := err.(*googleapi.Error); ok && apierr.Code == 404 {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete the pin route: %v", err)
+	}
+
+	if p == nil {
+		return nil
+	}
+
+	if err = h.blockingWait(h.svc.Routes.Insert(h.conf.Project, h.recordRoute(h.pname, p.Encode())).Do()); err != nil {
+		return fmt.Errorf("failed to create the pin route: %v", err)
+	}
+
+	return nil
+}
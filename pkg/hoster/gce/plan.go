@@ -0,0 +1,110 @@
+package gce
+
+import (
+	"fmt"
+	"path"
+
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+
+	"github.com/bpineau/cloud-floating-ip/pkg/plan"
+)
+
+// Plan returns the change to the route to the IP that a preempt (or destroy)
+// would make, without making it
+func (h *Hoster) Plan(destroy bool) (*plan.Plan, error) {
+	p := &plan.Plan{
+		IP:        h.conf.IP,
+		Instance:  h.conf.Instance,
+		Operation: plan.OperationPreempt,
+	}
+	if destroy {
+		p.Operation = plan.OperationDestroy
+	}
+
+	route, err := h.currentRoute()
+	if err != nil {
+		return nil, err
+	}
+
+	c := plan.Change{Table: path.Base(h.network)}
+	if route != nil {
+		c.Before = nextHop(route)
+	}
+
+	switch {
+	case destroy && route != nil && (route.NextHopInstance == h.selflink || h.conf.DestroyAll):
+		c.Action = plan.ActionDelete
+	case destroy:
+		return p, nil
+	case route == nil:
+		c.Action, c.After = plan.ActionCreate, h.selflink
+	case route.NextHopInstance != h.selflink:
+		c.Action, c.After = plan.ActionReplace, h.selflink
+	default:
+		return p, nil
+	}
+
+	p.Changes = []plan.Change{c}
+
+	return p, nil
+}
+
+// Apply makes the planned change to the route to the IP, unless the route
+// changed since the plan was computed
+func (h *Hoster) Apply(p *plan.Plan) error {
+	network := path.Base(h.network)
+
+	for _, c := range p.Changes {
+		if c.Table != network {
+			return fmt.Errorf("the plan changes the %s network, not %s", c.Table, network)
+		}
+
+		route, err := h.currentRoute()
+		if err != nil {
+			return err
+		}
+
+		current := ""
+		if route != nil {
+			current = nextHop(route)
+		}
+
+		if current != c.Before {
+			return &plan.StaleError{Reason: fmt.Sprintf("%s now routes %s to '%s', planned from '%s'",
+				network, h.conf.IP, current, c.Before)}
+		}
+
+		switch c.Action {
+		case plan.ActionCreate:
+			if err = h.expectOwner(); err == nil {
+				err = h.insertRoute(c.After)
+			}
+		case plan.ActionReplace:
+			if err = h.deleteRoute(); err == nil {
+				err = h.insertRoute(c.After)
+			}
+		case plan.ActionDelete:
+			err = h.deleteRoute()
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// currentRoute returns the route to the IP, or nil when there's none
+func (h *Hoster) currentRoute() (*compute.Route, error) {
+	route, err := h.svc.Routes.Get(h.conf.Project, h.rname).Context(*h.ctx).Do()
+	if apierr, ok := err.(*googleapi.Error); ok && apierr.Code == 404 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get route: %v", err)
+	}
+
+	return route, nil
+}
@@ -0,0 +1,7 @@
+package gce
+
+// Repair does nothing: the IP has a single route, which can't be inconsistent
+func (h *Hoster) Repair() error {
+	h.log.Infof("Nothing to repair, %s has a single route\n", h.conf.IP)
+	return nil
+}
@@ -6,40 +6,95 @@ import (
 	"github.com/bpineau/cloud-floating-ip/config"
 	"github.com/bpineau/cloud-floating-ip/pkg/hoster/aws"
 	"github.com/bpineau/cloud-floating-ip/pkg/hoster/gce"
+	"github.com/bpineau/cloud-floating-ip/pkg/inventory"
+	"github.com/bpineau/cloud-floating-ip/pkg/lease"
 	"github.com/bpineau/cloud-floating-ip/pkg/log"
+	"github.com/bpineau/cloud-floating-ip/pkg/ownership"
+	"github.com/bpineau/cloud-floating-ip/pkg/pin"
+	"github.com/bpineau/cloud-floating-ip/pkg/plan"
 )
 
 // Hoster represents an hosting provider (aws or gce)
 type Hoster interface {
-	Init(conf *config.CfiConfig, logger log.Logger)
+	Init(conf *config.CfiConfig, logger log.Logger) error
 	OnThisHoster() bool
 	Preempt() error
+	Fence() error
 	Status() bool
+	Owner() (string, error)
 	Destroy() error
+	GetLease() (*lease.Lease, error)
+	SwapLease(prev, next *lease.Lease) error
+	GetPin() (*pin.Pin, error)
+	SetPin(p *pin.Pin) error
+	InstanceHealthy(instance string) (bool, error)
+	LocalInstance() (string, error)
+	List() ([]*inventory.Entry, error)
+	Ownership() (*ownership.Ownership, error)
+	Repair() error
+	Plan(destroy bool) (*plan.Plan, error)
+	Apply(p *plan.Plan) error
 }
 
-var allHosters = map[string]Hoster{
-	"aws": &aws.Hoster{},
-	"gce": &gce.Hoster{},
+var allHosters = map[string]func() Hoster{
+	"aws": func() Hoster { return &aws.Hoster{} },
+	"gce": func() Hoster { return &gce.Hoster{} },
 }
 
-// GuessHoster returns the hoster described by name or found in instance's metadata
+// GuessHoster returns a new hoster described by name or found in instance's metadata
 func GuessHoster(name string) (Hoster, error) {
-	var h Hoster
-
 	if name != "" {
-		if host, ok := allHosters[name]; ok {
-			return host, nil
+		if newHoster, ok := allHosters[name]; ok {
+			return newHoster(), nil
 		}
 
 		return nil, errors.New("hoster not supported: " + name)
 	}
 
-	for _, h = range allHosters {
-		if h.OnThisHoster() {
+	for _, newHoster := range allHosters {
+		if h := newHoster(); h.OnThisHoster() {
 			return h, nil
 		}
 	}
 
 	return nil, errors.New("failed to guess the current host's hoster (neither aws or gce?)")
 }
+
+// Name returns the name of a hoster returned by GuessHoster or New
+func Name(h Hoster) string {
+	switch h.(type) {
+	case *aws.Hoster:
+		return "aws"
+	case *gce.Hoster:
+		return "gce"
+	}
+
+	return ""
+}
+
+// New returns a new hoster of the same kind as h, initialized with conf
+// (so we can prepare a hoster while h is still in use)
+func New(h Hoster, conf *config.CfiConfig, logger log.Logger) (Hoster, error) {
+	newHoster, ok := allHosters[Name(h)]
+	if !ok {
+		return nil, errors.New("unknown hoster")
+	}
+
+	other := newHoster()
+	if err := other.Init(conf, logger); err != nil {
+		return nil, err
+	}
+
+	return other, nil
+}
+
+// ForInstance returns a new hoster of the same kind as h, initialized to
+// route the IP to another instance (eg. a standby we hand the IP over to).
+// The target's zone is resolved by the hoster, as it may differ from ours.
+func ForInstance(h Hoster, conf *config.CfiConfig, instance string, logger log.Logger) (Hoster, error) {
+	c := *conf
+	c.Instance, c.Zone = instance, ""
+	c.Iface, c.Subnet, c.TargetIP = "", "", ""
+
+	return New(h, &c, logger)
+}
@@ -0,0 +1,27 @@
+package hoster
+
+import (
+	"testing"
+)
+
+func TestGuessHoster(t *testing.T) {
+	for _, name := range []string{"aws", "gce"} {
+		h, err := GuessHoster(name)
+		if err != nil {
+			t.Fatalf("GuessHoster(%s) = %v", name, err)
+		}
+
+		if got := Name(h); got != name {
+			t.Errorf("Name(GuessHoster(%s)) = '%s'", name, got)
+		}
+
+		other, _ := GuessHoster(name)
+		if other == h {
+			t.Errorf("GuessHoster(%s) returned the same hoster twice", name)
+		}
+	}
+
+	if _, err := GuessHoster("azure"); err == nil {
+		t.Error("GuessHoster(azure) didn't fail")
+	}
+}
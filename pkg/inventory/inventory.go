@@ -0,0 +1,143 @@
+// Package inventory describes the floating IPs routes found in a VPC (or GCE
+// network), as listed by hosters.
+package inventory
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Target is a route target (ENI, instance, gateway or next hop) and the
+// instance it resolves to, if any
+type Target struct {
+	ID       string
+	Instance string
+	Name     string
+	Tables   []string
+}
+
+// Entry is a destination and the targets of the routes to it
+type Entry struct {
+	Destination string
+	Targets     []*Target
+
+	// Missing are the selected tables without a route to the destination
+	Missing []string
+}
+
+// Agree returns true when all the tables route the destination to the same target
+func (e *Entry) Agree() bool {
+	return len(e.Targets) == 1 && len(e.Missing) == 0
+}
+
+// List gathers routes into entries
+type List struct {
+	tables  []string
+	entries map[string]*Entry
+}
+
+// NewList returns an empty list of the routes found in tables
+func NewList(tables []string) *List {
+	return &List{
+		tables:  tables,
+		entries: make(map[string]*Entry),
+	}
+}
+
+// Add records a route to destination via target, in table
+func (l *List) Add(destination, table, target string) {
+	e, ok := l.entries[destination]
+	if !ok {
+		e = &Entry{Destination: destination}
+		l.entries[destination] = e
+	}
+
+	for _, t := range e.Targets {
+		if t.ID == target {
+			t.Tables = append(t.Tables, table)
+			return
+		}
+	}
+
+	e.Targets = append(e.Targets, &Target{ID: target, Tables: []string{table}})
+}
+
+// Targets returns all the targets found, so hosters can resolve their instance
+func (l *List) Targets() []*Target {
+	var targets []*Target
+	for _, e := range l.entries {
+		targets = append(targets, e.Targets...)
+	}
+
+	return targets
+}
+
+// Entries returns the entries, sorted by destination
+func (l *List) Entries() []*Entry {
+	var entries []*Entry
+	for _, e := range l.entries {
+		e.Missing = nil
+		for _, table := range l.tables {
+			if !e.routedIn(table) {
+				e.Missing = append(e.Missing, table)
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Destination < entries[j].Destination
+	})
+
+	return entries
+}
+
+func (e *Entry) routedIn(table string) bool {
+	for _, t := range e.Targets {
+		for _, tbl := range t.Tables {
+			if tbl == table {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Print displays entries as a table, one line per target
+func Print(w io.Writer, entries []*Entry) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DESTINATION\tTARGET\tINSTANCE\tNAME\tTABLES\tAGREE")
+
+	for _, e := range entries {
+		agree := "no"
+		if e.Agree() {
+			agree = "yes"
+		}
+
+		dest := e.Destination
+		for _, t := range e.Targets {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", dest, t.ID, dash(t.Instance),
+				dash(t.Name), strings.Join(t.Tables, ","), agree)
+			dest, agree = "", ""
+		}
+
+		if len(e.Missing) > 0 {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", dest, "(none)", "-", "-",
+				strings.Join(e.Missing, ","), agree)
+		}
+	}
+
+	return tw.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
@@ -0,0 +1,60 @@
+package inventory
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEntries(t *testing.T) {
+	l := NewList([]string{"rtb-1", "rtb-2", "rtb-3"})
+	l.Add("10.200.0.2/32", "rtb-1", "eni-2")
+	l.Add("10.200.0.1/32", "rtb-1", "eni-1")
+	l.Add("10.200.0.1/32", "rtb-2", "eni-1")
+	l.Add("10.200.0.1/32", "rtb-3", "eni-1")
+	l.Add("10.200.0.2/32", "rtb-2", "eni-3")
+
+	if got := len(l.Targets()); got != 3 {
+		t.Errorf("Targets() returned %d targets, want 3", got)
+	}
+
+	entries := l.Entries()
+	if len(entries) != 2 || entries[0].Destination != "10.200.0.1/32" {
+		t.Fatalf("Entries() = %+v, want 2 entries sorted by destination", entries)
+	}
+
+	if !entries[0].Agree() {
+		t.Errorf("%s routes don't agree", entries[0].Destination)
+	}
+
+	if entries[1].Agree() {
+		t.Errorf("%s routes agree", entries[1].Destination)
+	}
+	if want := []string{"rtb-3"}; !reflect.DeepEqual(entries[1].Missing, want) {
+		t.Errorf("%s is missing from %v, want %v", entries[1].Destination, entries[1].Missing, want)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	l := NewList([]string{"rtb-1", "rtb-2"})
+	l.Add("10.200.0.1/32", "rtb-1", "eni-1")
+	l.Targets()[0].Instance = "i-1"
+
+	var buf bytes.Buffer
+	if err := Print(&buf, l.Entries()); err != nil {
+		t.Fatalf("Print() = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Print() = %s, want a header, the target and the missing tables", buf.String())
+	}
+
+	if fields := strings.Fields(lines[1]); !reflect.DeepEqual(fields, []string{"10.200.0.1/32", "eni-1", "i-1", "-", "rtb-1", "no"}) {
+		t.Errorf("Print() target line = %v", fields)
+	}
+	if fields := strings.Fields(lines[2]); !reflect.DeepEqual(fields, []string{"(none)", "-", "-", "rtb-2"}) {
+		t.Errorf("Print() missing line = %v", fields)
+	}
+}
@@ -0,0 +1,62 @@
+// Package lease describes the floating IP ownership lease, stored by hosters
+// (in per-generation security groups on AWS, or a route description on GCE).
+package lease
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrConflict is returned when the lease changed under our feet
+var ErrConflict = errors.New("lease was modified concurrently")
+
+// Lease records which instance holds the floating IP, and until when.
+// Generation is incremented on each change of holder, and serves as a
+// fencing token.
+type Lease struct {
+	Holder     string    `json:"holder"`
+	Expiry     time.Time `json:"expiry"`
+	Generation uint64    `json:"generation"`
+}
+
+// Expired returns true when the lease isn't valid anymore at the given time
+func (l *Lease) Expired(now time.Time) bool {
+	return l.Holder == "" || !now.Before(l.Expiry)
+}
+
+// Encode serializes a lease, to be stored by hosters
+func (l *Lease) Encode() string {
+	b, _ := json.Marshal(l)
+	return string(b)
+}
+
+// Decode parses an encoded lease
+func Decode(s string) (*Lease, error) {
+	l := &Lease{}
+	if err := json.Unmarshal([]byte(s), l); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Check returns an error when l (which may be nil) is held by another
+// instance than the given one at the given time
+func Check(l *Lease, instance string, now time.Time) error {
+	if l == nil || l.Expired(now) || l.Holder == instance {
+		return nil
+	}
+
+	return fmt.Errorf("lease is held by %s (generation %d)", l.Holder, l.Generation)
+}
+
+// Equal returns true when both leases are absent, or identical
+func Equal(a, b *Lease) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Holder == b.Holder && a.Generation == b.Generation && a.Expiry.Equal(b.Expiry)
+}
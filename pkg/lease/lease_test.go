@@ -0,0 +1,65 @@
+package lease
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		lease   *Lease
+		wantErr bool
+	}{
+		{"no lease", nil, false},
+		{"ours", &Lease{Holder: "i-1", Expiry: now.Add(time.Minute)}, false},
+		{"expired", &Lease{Holder: "i-2", Expiry: now.Add(-time.Minute)}, false},
+		{"released", &Lease{Holder: "", Expiry: now.Add(time.Minute)}, false},
+		{"held by another", &Lease{Holder: "i-2", Expiry: now.Add(time.Minute)}, true},
+	}
+
+	for _, tt := range tests {
+		if err := Check(tt.lease, "i-1", now); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Check() = %v, want error: %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	now := time.Now()
+	a := &Lease{Holder: "i-1", Expiry: now, Generation: 2}
+
+	tests := []struct {
+		name string
+		a, b *Lease
+		want bool
+	}{
+		{"both absent", nil, nil, true},
+		{"one absent", a, nil, false},
+		{"identical", a, &Lease{Holder: "i-1", Expiry: now, Generation: 2}, true},
+		{"other generation", a, &Lease{Holder: "i-1", Expiry: now, Generation: 3}, false},
+		{"other holder", a, &Lease{Holder: "i-2", Expiry: now, Generation: 2}, false},
+		{"renewed", a, &Lease{Holder: "i-1", Expiry: now.Add(time.Second), Generation: 2}, false},
+	}
+
+	for _, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: Equal() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	l := &Lease{Holder: "i-1", Expiry: time.Now().Add(time.Minute), Generation: 7}
+
+	decoded, err := Decode(l.Encode())
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+
+	if !Equal(l, decoded) {
+		t.Errorf("Decode(Encode()) = %+v, want %+v", decoded, l)
+	}
+}
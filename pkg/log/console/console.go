@@ -5,7 +5,8 @@ import (
 	"os"
 )
 
-// Logger implements Logger interface, display logs on stdout
+// Logger implements Logger interface, display logs on stdout (warnings and
+// errors on stderr)
 type Logger struct {
 	Quiet bool
 }
@@ -18,14 +19,24 @@ func (l *Logger) Infof(format string, v ...interface{}) {
 	fmt.Printf(format, v...)
 }
 
+// Warnf displays a formated string prefixed by a warning, even in quiet mode
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Warning: "+format, v...)
+}
+
+// Errorf displays a formated string, even in quiet mode
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, v...)
+}
+
 // Fatal displays a message then exit the program
 func (l *Logger) Fatal(v ...interface{}) {
-	fmt.Print(v...)
+	fmt.Fprint(os.Stderr, v...)
 	os.Exit(1)
 }
 
 // Fatalf displays a formated string then exit the program
 func (l *Logger) Fatalf(format string, v ...interface{}) {
-	fmt.Printf(format, v...)
+	fmt.Fprintf(os.Stderr, format, v...)
 	os.Exit(1)
 }
@@ -0,0 +1,93 @@
+// Package journald implements Logger, sending structured logs to the systemd journal
+package journald
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/coreos/go-systemd/journal"
+)
+
+// Logger implements Logger interface, sending logs to journald with structured fields
+type Logger struct {
+	Quiet bool
+
+	mu     sync.Mutex
+	fields map[string]string
+}
+
+// Enabled returns true when our standard output is connected to the journal
+// (as for systemd services, which set JOURNAL_STREAM to its device:inode),
+// so we'd better log natively there
+func Enabled() bool {
+	var dev, ino uint64
+	if _, err := fmt.Sscanf(os.Getenv("JOURNAL_STREAM"), "%d:%d", &dev, &ino); err != nil {
+		return false
+	}
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(os.Stdout.Fd()), &st); err != nil {
+		return false
+	}
+
+	return uint64(st.Dev) == dev && uint64(st.Ino) == ino
+}
+
+// SetField attaches a structured field (eg. CFI_ROLE) to the following messages
+func (l *Logger) SetField(key, value string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.fields == nil {
+		l.fields = make(map[string]string)
+	}
+	l.fields[key] = value
+}
+
+// Infof logs a formated string, honoring the Quiet config setting
+func (l *Logger) Infof(format string, v ...interface{}) {
+	if l.Quiet {
+		return
+	}
+	l.send(journal.PriInfo, fmt.Sprintf(format, v...))
+}
+
+// Warnf logs a formated string as a warning, even in quiet mode
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.send(journal.PriWarning, fmt.Sprintf(format, v...))
+}
+
+// Errorf logs a formated string as an error, even in quiet mode
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.send(journal.PriErr, fmt.Sprintf(format, v...))
+}
+
+// Fatal logs a message then exit the program
+func (l *Logger) Fatal(v ...interface{}) {
+	l.send(journal.PriCrit, fmt.Sprint(v...))
+	os.Exit(1)
+}
+
+// Fatalf logs a formated string then exit the program
+func (l *Logger) Fatalf(format string, v ...interface{}) {
+	l.send(journal.PriCrit, fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
+func (l *Logger) send(priority journal.Priority, message string) {
+	l.mu.Lock()
+	vars := make(map[string]string, len(l.fields))
+	for k, v := range l.fields {
+		vars[k] = v
+	}
+	l.mu.Unlock()
+
+	message = strings.TrimSuffix(message, "\n")
+
+	if err := journal.Send(message, priority, vars); err != nil {
+		fmt.Println(message)
+	}
+}
@@ -3,6 +3,20 @@ package log
 // Logger handle logs, ideally honoring the Quiet config parameter
 type Logger interface {
 	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
 	Fatalf(format string, v ...interface{})
 	Fatal(v ...interface{})
 }
+
+// FieldLogger loggers can attach structured fields to their messages
+type FieldLogger interface {
+	SetField(key, value string)
+}
+
+// SetField attaches a structured field to l's following messages, when l supports it
+func SetField(l Logger, key, value string) {
+	if f, ok := l.(FieldLogger); ok {
+		f.SetField(key, value)
+	}
+}
@@ -0,0 +1,159 @@
+// Package aws polls the EC2 instance metadata for spot interruption and
+// autoscaling termination notices (the EC2 metadata service can't long poll).
+package aws
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	metadataTimeout     = 2 * time.Second
+
+	spotActionPath     = "spot/instance-action"
+	lifecycleStatePath = "autoscaling/target-lifecycle-state"
+
+	lifecycleTerminated = "Terminated"
+
+	// error code of the metadata requests for undefined values
+	codeNotFound = "NotFoundError"
+)
+
+// Watcher represents an EC2 metadata notices watcher
+type Watcher struct {
+	// Endpoint overrides the instance metadata service address (eg. a local fake, for tests)
+	Endpoint string
+
+	// Interval is the metadata polling interval
+	Interval time.Duration
+
+	log      log.Logger
+	metadata *ec2metadata.EC2Metadata
+}
+
+// Init prepares the EC2 metadata watcher for usage
+func (w *Watcher) Init(conf *config.CfiConfig, logger log.Logger) error {
+	w.log = logger
+
+	if w.Interval <= 0 {
+		w.Interval = defaultPollInterval
+	}
+
+	return w.client()
+}
+
+// OnThisHoster returns true when we run on an aws instance
+func (w *Watcher) OnThisHoster() bool {
+	if err := w.client(); err != nil {
+		return false
+	}
+
+	return w.metadata.Available()
+}
+
+// Watch polls the spot and autoscaling notices, until ctx is cancelled. Sends
+// an empty notice when the previous one is withdrawn.
+func (w *Watcher) Watch(ctx context.Context, notices chan<- string) error {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	last := ""
+
+	for {
+		notice, err := w.poll()
+		if err != nil {
+			if ctx.Err() == nil {
+				w.log.Errorf("Failed to poll instance metadata: %v\n", err)
+			}
+		} else if notice != last {
+			last = notice
+			select {
+			case notices <- notice:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll returns the current notice, if any
+func (w *Watcher) poll() (string, error) {
+	action, err := w.get(spotActionPath)
+	if err != nil {
+		return "", err
+	}
+
+	if action != "" {
+		return fmt.Sprintf("spot instance-action: %s", action), nil
+	}
+
+	state, err := w.get(lifecycleStatePath)
+	if err != nil {
+		return "", err
+	}
+
+	if state == lifecycleTerminated {
+		return "autoscaling target-lifecycle-state: " + state, nil
+	}
+
+	return "", nil
+}
+
+// get returns a metadata value, or an empty string when it isn't defined
+func (w *Watcher) get(path string) (string, error) {
+	value, err := w.metadata.GetMetadata(path)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == codeNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s: %v", path, err)
+	}
+
+	return strings.TrimSpace(value), nil
+}
+
+// client prepares the metadata client, reporting undefined values (404) with
+// a codeNotFound error
+func (w *Watcher) client() error {
+	if w.metadata != nil {
+		return nil
+	}
+
+	sess, err := session.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create an aws session: %v", err)
+	}
+
+	cfg := aws.NewConfig().WithHTTPClient(&http.Client{Timeout: metadataTimeout})
+	if w.Endpoint != "" {
+		cfg = cfg.WithEndpoint(w.Endpoint)
+	}
+
+	w.metadata = ec2metadata.New(sess, cfg)
+	w.metadata.Handlers.UnmarshalError.PushBack(func(r *request.Request) {
+		if r.HTTPResponse.StatusCode == http.StatusNotFound {
+			r.Error = awserr.New(codeNotFound, r.Operation.HTTPPath+" not found", r.Error)
+		}
+	})
+
+	return nil
+}
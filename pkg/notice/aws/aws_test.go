@@ -0,0 +1,135 @@
+package aws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/log/console"
+)
+
+// fakeMetadata serves the metadata values it holds (404 for the others)
+type fakeMetadata struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (f *fakeMetadata) set(path, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[path] = value
+}
+
+func (f *fakeMetadata) unset(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.values, path)
+}
+
+func (f *fakeMetadata) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	value, ok := f.values[strings.TrimPrefix(r.URL.Path, "/latest/meta-data/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Write([]byte(value))
+}
+
+func newWatcher(t *testing.T) (*Watcher, *fakeMetadata, func()) {
+	fake := &fakeMetadata{values: map[string]string{"instance-id": "i-0123"}}
+	srv := httptest.NewServer(fake)
+
+	w := &Watcher{Endpoint: srv.URL + "/latest", Interval: 10 * time.Millisecond}
+	if err := w.Init(&config.CfiConfig{}, &console.Logger{Quiet: true}); err != nil {
+		t.Fatalf("Init() = %v", err)
+	}
+
+	return w, fake, srv.Close
+}
+
+func TestOnThisHoster(t *testing.T) {
+	w, _, stop := newWatcher(t)
+	defer stop()
+
+	if !w.OnThisHoster() {
+		t.Error("OnThisHoster() = false with a metadata service")
+	}
+
+	w = &Watcher{Endpoint: "http://127.0.0.1:1/latest"}
+	if w.OnThisHoster() {
+		t.Error("OnThisHoster() = true without metadata service")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		value  string
+		notice string
+	}{
+		{"spot interruption", spotActionPath, `{"action": "terminate", "time": "2018-03-09T19:12:04Z"}`,
+			`spot instance-action: {"action": "terminate", "time": "2018-03-09T19:12:04Z"}`},
+		{"autoscaling termination", lifecycleStatePath, "Terminated",
+			"autoscaling target-lifecycle-state: Terminated"},
+		{"autoscaling in service", lifecycleStatePath, "InService", ""},
+	}
+
+	for _, tt := range tests {
+		w, fake, stop := newWatcher(t)
+		fake.set(lifecycleStatePath, "InService")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		notices := make(chan string, 1)
+		done := make(chan error, 1)
+		go func() { done <- w.Watch(ctx, notices) }()
+
+		// no notice before the change
+		select {
+		case n := <-notices:
+			t.Errorf("%s: unexpected notice '%s'", tt.name, n)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		fake.set(tt.path, tt.value)
+
+		select {
+		case n := <-notices:
+			if n != tt.notice {
+				t.Errorf("%s: got notice '%s', want '%s'", tt.name, n, tt.notice)
+			}
+		case <-time.After(time.Second):
+			if tt.notice != "" {
+				t.Errorf("%s: no notice", tt.name)
+			}
+		}
+
+		// the notice is withdrawn
+		if tt.notice != "" {
+			fake.unset(tt.path)
+			select {
+			case n := <-notices:
+				if n != "" {
+					t.Errorf("%s: got notice '%s' once withdrawn", tt.name, n)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("%s: the withdrawal wasn't reported", tt.name)
+			}
+		}
+
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("%s: Watch() = %v", tt.name, err)
+		}
+		stop()
+	}
+}
@@ -0,0 +1,137 @@
+// Package gce watches the GCE instance metadata for preemption and host
+// maintenance events, using the metadata server's wait-for-change long polls.
+// Set the GCE_METADATA_HOST environment variable to use another metadata
+// server (eg. a local fake, for tests).
+package gce
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+
+	"cloud.google.com/go/compute/metadata"
+)
+
+const (
+	preemptedPath   = "instance/preempted"
+	maintenancePath = "instance/maintenance-event"
+
+	noMaintenance = "NONE"
+
+	// prefix of the maintenance events that stop the instance (others, like
+	// MIGRATE_ON_HOST_MAINTENANCE, are transparent)
+	terminateMaintenance = "TERMINATE"
+
+	// delay before subscribing again after a failure
+	retryDelay = 5 * time.Second
+)
+
+// Watcher represents a GCE metadata notices watcher
+type Watcher struct {
+	log log.Logger
+}
+
+// Init prepares the GCE metadata watcher for usage
+func (w *Watcher) Init(conf *config.CfiConfig, logger log.Logger) error {
+	w.log = logger
+	return nil
+}
+
+// OnThisHoster returns true when we run on a gce instance
+func (w *Watcher) OnThisHoster() bool {
+	return metadata.OnGCE()
+}
+
+// event is a notice from a metadata path, or its end (empty notice)
+type event struct {
+	path   string
+	notice string
+}
+
+// Watch waits for preemption or terminating maintenance events, until ctx
+// is cancelled. Sends an empty notice once all the events are over.
+func (w *Watcher) Watch(ctx context.Context, notices chan<- string) error {
+	events := make(chan event)
+
+	go w.subscribe(ctx, preemptedPath, events, func(v string) bool {
+		return v == "TRUE"
+	})
+	go w.subscribe(ctx, maintenancePath, events, func(v string) bool {
+		return strings.HasPrefix(v, terminateMaintenance)
+	})
+
+	pending := make(map[string]bool)
+
+	for {
+		var e event
+		select {
+		case <-ctx.Done():
+			return nil
+		case e = <-events:
+		}
+
+		pending[e.path] = e.notice != ""
+		if e.notice == "" && (pending[preemptedPath] || pending[maintenancePath]) {
+			continue
+		}
+
+		select {
+		case notices <- e.notice:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// subscribe sends an event when path's value changes to a value matching
+// isNotice, and when it changes back. Metadata subscriptions can't be
+// cancelled: once ctx is cancelled, the subscription ends on the next change
+// of path's value.
+func (w *Watcher) subscribe(ctx context.Context, path string, events chan<- event, isNotice func(string) bool) {
+	pending := false
+
+	for {
+		err := metadata.Subscribe(path, func(v string, ok bool) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			v = strings.TrimSpace(v)
+			notice := ok && isNotice(v)
+			if notice == pending {
+				return nil
+			}
+			pending = notice
+
+			e := event{path: path}
+			if notice {
+				e.notice = path + ": " + v
+			}
+
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			return nil
+		})
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			w.log.Errorf("Failed to watch %s metadata: %v\n", path, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay):
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package gce
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/log/console"
+)
+
+// fakeMetadata serves metadata values, and answers wait_for_change requests
+// on a value's next change (or when closed)
+type fakeMetadata struct {
+	mu      sync.Mutex
+	values  map[string]string
+	version int
+	changed chan struct{}
+	closed  chan struct{}
+}
+
+func newFakeMetadata() *fakeMetadata {
+	return &fakeMetadata{
+		values: map[string]string{
+			preemptedPath:   "FALSE",
+			maintenancePath: noMaintenance,
+		},
+		changed: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (f *fakeMetadata) set(path, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.values[path] = value
+	f.version++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeMetadata) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/computeMetadata/v1/")
+
+	f.mu.Lock()
+	etag, changed := fmt.Sprintf("%d", f.version), f.changed
+	f.mu.Unlock()
+
+	if r.URL.Query().Get("wait_for_change") == "true" && r.URL.Query().Get("last_etag") == etag {
+		select {
+		case <-changed:
+		case <-f.closed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	value, ok := f.values[path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Etag", fmt.Sprintf("%d", f.version))
+	w.Write([]byte(value))
+}
+
+func TestWatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		value  string
+		notice string
+		reset  string // value ending the notice
+	}{
+		{"preemption", preemptedPath, "TRUE", "instance/preempted: TRUE", "FALSE"},
+		{"maintenance", maintenancePath, "TERMINATE_ON_HOST_MAINTENANCE",
+			"instance/maintenance-event: TERMINATE_ON_HOST_MAINTENANCE", noMaintenance},
+		{"live migration", maintenancePath, "MIGRATE_ON_HOST_MAINTENANCE", "", ""},
+		{"no maintenance", maintenancePath, noMaintenance, "", ""},
+	}
+
+	defer os.Unsetenv("GCE_METADATA_HOST")
+
+	for _, tt := range tests {
+		fake := newFakeMetadata()
+		srv := httptest.NewServer(fake)
+		os.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(srv.URL, "http://"))
+
+		w := &Watcher{}
+		if err := w.Init(&config.CfiConfig{}, &console.Logger{Quiet: true}); err != nil {
+			t.Fatalf("Init() = %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		notices := make(chan string, 1)
+		done := make(chan error, 1)
+		go func() { done <- w.Watch(ctx, notices) }()
+
+		// no notice before the change
+		select {
+		case n := <-notices:
+			t.Errorf("%s: unexpected notice '%s'", tt.name, n)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		fake.set(tt.path, tt.value)
+
+		select {
+		case n := <-notices:
+			if n != tt.notice {
+				t.Errorf("%s: got notice '%s', want '%s'", tt.name, n, tt.notice)
+			}
+		case <-time.After(time.Second):
+			if tt.notice != "" {
+				t.Errorf("%s: no notice", tt.name)
+			}
+		}
+
+		if tt.reset != "" {
+			fake.set(tt.path, tt.reset)
+			select {
+			case n := <-notices:
+				if n != "" {
+					t.Errorf("%s: got notice '%s' once over", tt.name, n)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("%s: the end of the notice wasn't reported", tt.name)
+			}
+		}
+
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("%s: Watch() = %v", tt.name, err)
+		}
+
+		// ends the pending subscriptions
+		close(fake.closed)
+		srv.Close()
+	}
+}
+
+func TestWatchPending(t *testing.T) {
+	fake := newFakeMetadata()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	defer close(fake.closed)
+
+	os.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(srv.URL, "http://"))
+	defer os.Unsetenv("GCE_METADATA_HOST")
+
+	w := &Watcher{}
+	if err := w.Init(&config.CfiConfig{}, &console.Logger{Quiet: true}); err != nil {
+		t.Fatalf("Init() = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	notices := make(chan string, 1)
+	go w.Watch(ctx, notices)
+	time.Sleep(100 * time.Millisecond)
+
+	for _, change := range [][2]string{
+		{maintenancePath, "TERMINATE_ON_HOST_MAINTENANCE"},
+		{preemptedPath, "TRUE"},
+	} {
+		fake.set(change[0], change[1])
+		select {
+		case n := <-notices:
+			if n == "" {
+				t.Errorf("got an empty notice after %s changed", change[0])
+			}
+		case <-time.After(time.Second):
+			t.Errorf("no notice after %s changed", change[0])
+		}
+	}
+
+	// the preemption is still pending
+	fake.set(maintenancePath, noMaintenance)
+	select {
+	case n := <-notices:
+		t.Errorf("unexpected notice '%s' while preempted", n)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,52 @@
+// Package notice watches the local instance metadata for imminent termination
+// notices (spot interruptions, autoscaling terminations, GCE preemptions or
+// maintenance events), so the instance can give the floating IP away first.
+package notice
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+	"github.com/bpineau/cloud-floating-ip/pkg/notice/aws"
+	"github.com/bpineau/cloud-floating-ip/pkg/notice/gce"
+)
+
+// Watcher represents a termination notices source (aws or gce)
+type Watcher interface {
+	// Init prepares the watcher for usage
+	Init(conf *config.CfiConfig, logger log.Logger) error
+
+	// OnThisHoster returns true when the watcher's metadata service is reachable
+	OnThisHoster() bool
+
+	// Watch sends a description of each new notice on the notices channel,
+	// and an empty string when the notice is withdrawn (eg. a maintenance
+	// event is over), until the context is cancelled.
+	Watch(ctx context.Context, notices chan<- string) error
+}
+
+var allWatchers = map[string]func() Watcher{
+	"aws": func() Watcher { return &aws.Watcher{} },
+	"gce": func() Watcher { return &gce.Watcher{} },
+}
+
+// GuessWatcher returns the watcher for the hoster described by name, or found in instance's metadata
+func GuessWatcher(name string) (Watcher, error) {
+	if name != "" {
+		if newWatcher, ok := allWatchers[name]; ok {
+			return newWatcher(), nil
+		}
+
+		return nil, errors.New("preemption notices not supported on: " + name)
+	}
+
+	for _, newWatcher := range allWatchers {
+		if w := newWatcher(); w.OnThisHoster() {
+			return w, nil
+		}
+	}
+
+	return nil, errors.New("failed to guess the current host's hoster (neither aws or gce?)")
+}
@@ -0,0 +1,114 @@
+// Package notify runs user commands (hooks) on state transitions, like
+// keepalived's notify_master, notify_backup and notify_fault scripts.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+	"github.com/bpineau/cloud-floating-ip/pkg/shell"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// Notifier runs the hook configured for each state
+type Notifier struct {
+	conf    *config.CfiConfig
+	log     log.Logger
+	hoster  string
+	hooks   map[string]string
+	timeout time.Duration
+}
+
+// New returns a notifier running conf's hooks
+func New(conf *config.CfiConfig, hoster string, logger log.Logger) *Notifier {
+	timeout := conf.NotifyTimeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return &Notifier{
+		conf:   conf,
+		log:    logger,
+		hoster: hoster,
+		hooks: map[string]string{
+			"primary": conf.NotifyPrimary,
+			"standby": conf.NotifyStandby,
+			"fault":   conf.NotifyFault,
+			// an instance about to be terminated can't carry the IP either
+			"leaving": conf.NotifyFault,
+		},
+		timeout: timeout,
+	}
+}
+
+// Enabled returns true when at least one hook is configured
+func (n *Notifier) Enabled() bool {
+	return n.conf.NotifyPrimary != "" || n.conf.NotifyStandby != "" || n.conf.NotifyFault != ""
+}
+
+// Run runs the hook for state (if any), until it exits or times out
+func (n *Notifier) Run(state, previous string) error {
+	command := n.hooks[state]
+	if command == "" {
+		return nil
+	}
+
+	n.log.Infof("Running %s hook: %s\n", state, command)
+
+	return n.exec(command, "CFI_STATE="+state, "CFI_PREVIOUS_STATE="+previous)
+}
+
+// Alert runs the alert command (if any) describing a problem, until it exits or times out
+func (n *Notifier) Alert(reason string) error {
+	command := n.conf.AlertCommand
+	if command == "" {
+		return nil
+	}
+
+	n.log.Infof("Running alert command: %s\n", command)
+
+	return n.exec(command, "CFI_REASON="+reason)
+}
+
+// Drain runs the drain command (if any), so the instance stops accepting
+// new connections before the IP moves away
+func (n *Notifier) Drain() error {
+	command := n.conf.DrainCommand
+	if command == "" {
+		return nil
+	}
+
+	n.log.Infof("Running drain command: %s\n", command)
+
+	return n.exec(command, "CFI_STATE=draining")
+}
+
+// exec runs command, killed (with its children) on timeout
+func (n *Notifier) exec(command string, env ...string) error {
+	if n.conf.DryRun {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+
+	env = append([]string{
+		"CFI_IP=" + n.conf.IP,
+		"CFI_INSTANCE=" + n.conf.Instance,
+		"CFI_HOSTER=" + n.hoster,
+	}, env...)
+
+	out, err := shell.Run(ctx, command, env, nil)
+	if err == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s: %s", n.timeout, out)
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/log/console"
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	conf := &config.CfiConfig{
+		IP:            "10.200.0.1",
+		Instance:      "i-1",
+		NotifyPrimary: "echo $CFI_IP $CFI_INSTANCE $CFI_HOSTER $CFI_STATE $CFI_PREVIOUS_STATE > " + out,
+	}
+	n := New(conf, "aws", &console.Logger{Quiet: true})
+
+	if !n.Enabled() {
+		t.Error("Enabled() = false with a primary hook")
+	}
+
+	if err = n.Run("standby", "primary"); err != nil {
+		t.Errorf("Run() of a state without hook = %v", err)
+	}
+
+	if err = n.Run("primary", "standby"); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("the hook didn't run: %v", err)
+	}
+
+	if got, want := strings.TrimSpace(string(b)), "10.200.0.1 i-1 aws primary standby"; got != want {
+		t.Errorf("the hook got '%s', want '%s'", got, want)
+	}
+}
+
+func TestExec(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		wantErr string
+		maxTime time.Duration
+	}{
+		{"success", "true", "", time.Second},
+		{"failure", "echo oops; exit 3", "exit status 3: oops", time.Second},
+		{"timeout", "echo slow; sleep 10", "timed out after 200ms: slow", 2 * time.Second},
+		{"background process holding the output", "sleep 10 &", "", time.Second},
+		{"background process killed on timeout", "sleep 10 & wait", "timed out after 200ms: ", 2 * time.Second},
+	}
+
+	for _, tt := range tests {
+		n := New(&config.CfiConfig{NotifyTimeout: 200 * time.Millisecond}, "aws", &console.Logger{Quiet: true})
+
+		start := time.Now()
+		err := n.exec(tt.command)
+		elapsed := time.Since(start)
+
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: exec() = %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || strings.TrimSpace(err.Error()) != strings.TrimSpace(tt.wantErr)) {
+			t.Errorf("%s: exec() = %v, want '%s'", tt.name, err, tt.wantErr)
+		}
+		if elapsed > tt.maxTime {
+			t.Errorf("%s: exec() took %s", tt.name, elapsed)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	n := New(&config.CfiConfig{DryRun: true}, "aws", &console.Logger{Quiet: true})
+
+	if err := n.exec("exit 1"); err != nil {
+		t.Errorf("exec() = %v in dry-run mode", err)
+	}
+}
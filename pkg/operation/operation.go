@@ -12,4 +12,28 @@ const (
 
 	// CfiDestroy purges all routes we've created
 	CfiDestroy
+
+	// CfiDaemon keeps routes in the desired state until we're signaled
+	CfiDaemon
+
+	// CfiPin freezes the IP ownership on the instance (maintenance pin)
+	CfiPin
+
+	// CfiUnpin removes the maintenance pin
+	CfiUnpin
+
+	// CfiList enumerates all the floating IPs routes
+	CfiList
+
+	// CfiRepair makes all the route tables agree on the IP target
+	CfiRepair
+
+	// CfiMove routes the IP to another instance
+	CfiMove
+
+	// CfiPlan computes the route changes of a preempt or destroy, without making them
+	CfiPlan
+
+	// CfiApply makes the route changes of a saved plan
+	CfiApply
 )
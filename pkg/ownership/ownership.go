@@ -0,0 +1,189 @@
+// Package ownership describes who the routes to a floating IP target, table
+// by table (AWS route tables, or the GCE network), as reported by hosters.
+package ownership
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// StateAbsent tables have no route to the IP
+	StateAbsent = "absent"
+
+	// StateForeign tables route the IP to another target
+	StateForeign = "foreign"
+
+	// StateUs tables route the IP to our instance
+	StateUs = "us"
+
+	// StateBlackhole tables route the IP to a target that's gone (or stopped)
+	StateBlackhole = "blackhole"
+
+	// OwnerNone is the expected owner of an IP without routes
+	OwnerNone = "none"
+)
+
+// MismatchError is returned when the IP isn't owned by the expected owner
+type MismatchError struct {
+	Reason string
+}
+
+func (e *MismatchError) Error() string {
+	return "unexpected owner: " + e.Reason
+}
+
+// Mismatch returns a *MismatchError: what routes ip to owner (a route target,
+// empty when absent), not to expected
+func Mismatch(what, ip, owner, expected string) error {
+	if owner == "" {
+		owner = OwnerNone
+	}
+
+	return &MismatchError{Reason: fmt.Sprintf("%s routes %s to %s, not %s", what, ip, owner, expected)}
+}
+
+// Table is the state of the route to the IP in a route table (or network),
+// and the instance it targets (if any)
+type Table struct {
+	ID     string `json:"id"`
+	State  string `json:"state"`
+	Target string `json:"target,omitempty"`
+	Owner  string `json:"owner,omitempty"`
+}
+
+// Ownership describes the routes to the IP: the owner is the instance the
+// first routed table targets (empty when nobody owns the IP)
+type Ownership struct {
+	IP       string     `json:"ip"`
+	Owner    string     `json:"owner,omitempty"`
+	Name     string     `json:"name,omitempty"`
+	Target   string     `json:"target,omitempty"`
+	Network  string     `json:"network"`
+	Tables   []Table    `json:"tables"`
+	Modified *time.Time `json:"modified,omitempty"`
+}
+
+// Owned returns true when all the tables route the IP to our instance
+func (o *Ownership) Owned() bool {
+	for _, t := range o.Tables {
+		if t.State != StateUs {
+			return false
+		}
+	}
+
+	return len(o.Tables) > 0
+}
+
+// Matches returns true when expected is one of a route target ids (instance,
+// ENI or next hop), or is OwnerNone and the route has no target
+func Matches(expected string, ids ...string) bool {
+	none := true
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		if id == expected {
+			return true
+		}
+		none = false
+	}
+
+	return none && expected == OwnerNone
+}
+
+// Expect returns a *MismatchError unless expected owns the IP in all the
+// tables (see Matches: tables without route only match OwnerNone)
+func (o *Ownership) Expect(expected string) error {
+	for _, t := range o.Tables {
+		if !Matches(expected, t.Owner, t.Target) {
+			return Mismatch(t.ID, o.IP, t.Target, expected)
+		}
+	}
+
+	if len(o.Tables) == 0 && !Matches(expected) {
+		return Mismatch(o.Network, o.IP, "", expected)
+	}
+
+	return nil
+}
+
+// Majority returns the target most tables route the IP to: live targets win
+// over blackholes, and ties go to the first table. Empty when no table routes
+// the IP.
+func (o *Ownership) Majority() string {
+	for _, live := range []bool{true, false} {
+		votes := make(map[string]int)
+		var voters []Table
+		for _, t := range o.Tables {
+			if t.State == StateAbsent || (live && t.State == StateBlackhole) {
+				continue
+			}
+
+			votes[t.Target]++
+			voters = append(voters, t)
+		}
+
+		best := ""
+		for _, t := range voters {
+			if best == "" || votes[t.Target] > votes[best] {
+				best = t.Target
+			}
+		}
+
+		if best != "" {
+			return best
+		}
+	}
+
+	return ""
+}
+
+// Divergent returns the tables that don't route the IP to the majority target
+func (o *Ownership) Divergent() []string {
+	majority := o.Majority()
+
+	var ids []string
+	for _, t := range o.Tables {
+		if t.Target != majority {
+			ids = append(ids, t.ID)
+		}
+	}
+
+	return ids
+}
+
+// Print displays the ownership details, one line per attribute or table
+func Print(w io.Writer, o *Ownership) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	owner := o.Owner
+	if owner == "" {
+		owner = "none"
+	}
+	if o.Name != "" {
+		owner += " (" + o.Name + ")"
+	}
+
+	fmt.Fprintf(tw, "owner\t%s\n", owner)
+	if o.Target != "" {
+		fmt.Fprintf(tw, "target\t%s\n", o.Target)
+	}
+	fmt.Fprintf(tw, "network\t%s\n", o.Network)
+	if o.Modified != nil {
+		fmt.Fprintf(tw, "modified\t%s\n", o.Modified.Format(time.RFC3339))
+	}
+
+	for _, t := range o.Tables {
+		fmt.Fprintf(tw, "table\t%s\t%s\t%s\n", t.ID, t.State, t.Target)
+	}
+
+	if divergent := o.Divergent(); len(divergent) > 0 {
+		fmt.Fprintf(tw, "divergent\t%s\n", strings.Join(divergent, ","))
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,54 @@
+package ownership
+
+import (
+	"testing"
+)
+
+func TestExpect(t *testing.T) {
+	us := Table{ID: "rtb-1", State: StateUs, Target: "eni-1", Owner: "i-1"}
+	other := Table{ID: "rtb-2", State: StateForeign, Target: "eni-2", Owner: "i-2"}
+	absent := Table{ID: "rtb-3", State: StateAbsent}
+
+	tests := []struct {
+		name     string
+		tables   []Table
+		expected string
+		match    bool
+	}{
+		{"owner", []Table{us, us}, "i-1", true},
+		{"target", []Table{us}, "eni-1", true},
+		{"other owner", []Table{us}, "i-2", false},
+		{"owner in a single table", []Table{us, other}, "i-1", false},
+		{"absent table", []Table{us, absent}, "i-1", false},
+		{"none without routes", []Table{absent, absent}, OwnerNone, true},
+		{"none with a route", []Table{absent, us}, OwnerNone, false},
+		{"no table", nil, OwnerNone, true},
+		{"instance without table", nil, "i-1", false},
+	}
+
+	for _, tt := range tests {
+		o := &Ownership{IP: "10.200.0.1", Network: "vpc-1", Tables: tt.tables}
+
+		err := o.Expect(tt.expected)
+		if _, ok := err.(*MismatchError); ok == tt.match || (err != nil && !ok) {
+			t.Errorf("%s: Expect(%s) = %v, want match=%v", tt.name, tt.expected, err, tt.match)
+		}
+	}
+}
+
+func TestMajority(t *testing.T) {
+	o := &Ownership{Tables: []Table{
+		{ID: "rtb-1", State: StateBlackhole, Target: "eni-1"},
+		{ID: "rtb-2", State: StateBlackhole, Target: "eni-1"},
+		{ID: "rtb-3", State: StateForeign, Target: "eni-2"},
+		{ID: "rtb-4", State: StateAbsent},
+	}}
+
+	if got := o.Majority(); got != "eni-2" {
+		t.Errorf("Majority() = '%s', want the live target", got)
+	}
+
+	if got := o.Divergent(); len(got) != 3 {
+		t.Errorf("Divergent() = %v", got)
+	}
+}
@@ -0,0 +1,94 @@
+// Package pin describes maintenance pins, freezing the floating IP on an
+// instance. Pins are stored along the routes by hosters (as route table tags
+// on AWS, or a route description on GCE).
+package pin
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Error is returned when a pin forbids the requested change
+type Error struct {
+	Pin *Pin
+}
+
+func (e *Error) Error() string {
+	return "IP is pinned " + e.Pin.String()
+}
+
+// Source stores pins (as hosters do)
+type Source interface {
+	GetPin() (*Pin, error)
+}
+
+// Pin records which instance must keep the floating IP, why, and until when
+// (a zero Expiry means until unpinned)
+type Pin struct {
+	Holder string    `json:"holder"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+	Expiry time.Time `json:"expiry,omitempty"`
+}
+
+// Active returns true when the pin is still valid at the given time
+func (p *Pin) Active(now time.Time) bool {
+	if p == nil || p.Holder == "" {
+		return false
+	}
+
+	return p.Expiry.IsZero() || now.Before(p.Expiry)
+}
+
+func (p *Pin) String() string {
+	s := "to " + p.Holder
+	if !p.Expiry.IsZero() {
+		s += " until " + p.Expiry.Format(time.RFC3339)
+	}
+	if p.Reason != "" {
+		s += " (" + p.Reason + ")"
+	}
+
+	return s
+}
+
+// Encode serializes a pin, to be stored by hosters
+func (p *Pin) Encode() string {
+	b, _ := json.Marshal(p)
+	return string(b)
+}
+
+// Decode parses an encoded pin
+func Decode(s string) (*Pin, error) {
+	p := &Pin{}
+	if err := json.Unmarshal([]byte(s), p); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Check returns an *Error when p (which may be nil) forbids instance to take
+// the IP over at the given time. An empty instance (as when removing routes)
+// is forbidden by any active pin.
+func Check(p *Pin, instance string, now time.Time) error {
+	if !p.Active(now) || p.Holder == instance {
+		return nil
+	}
+
+	return &Error{Pin: p}
+}
+
+// Verify checks the current pin stored by src, as Check does (unless force)
+func Verify(src Source, instance string, force bool) error {
+	if force {
+		return nil
+	}
+
+	p, err := src.GetPin()
+	if err != nil {
+		return err
+	}
+
+	return Check(p, instance, time.Now())
+}
@@ -0,0 +1,71 @@
+package pin
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeSource struct {
+	pin *Pin
+	err error
+}
+
+func (s *fakeSource) GetPin() (*Pin, error) { return s.pin, s.err }
+
+func TestCheck(t *testing.T) {
+	now := time.Now()
+	pinned := &Pin{Holder: "i-1", Since: now}
+	expired := &Pin{Holder: "i-1", Since: now.Add(-time.Hour), Expiry: now.Add(-time.Minute)}
+
+	tests := []struct {
+		name     string
+		pin      *Pin
+		instance string
+		pinned   bool
+	}{
+		{"no pin", nil, "i-2", false},
+		{"holder", pinned, "i-1", false},
+		{"other instance", pinned, "i-2", true},
+		{"route deletion", pinned, "", true},
+		{"expired pin", expired, "i-2", false},
+	}
+
+	for _, tt := range tests {
+		err := Check(tt.pin, tt.instance, now)
+		if _, ok := err.(*Error); ok != tt.pinned {
+			t.Errorf("%s: Check() = %v, want pinned=%v", tt.name, err, tt.pinned)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	src := &fakeSource{pin: &Pin{Holder: "i-1", Reason: "maintenance"}}
+
+	err := Verify(src, "i-2", false)
+	if err == nil || err.Error() != "IP is pinned to i-1 (maintenance)" {
+		t.Errorf("Verify() = %v", err)
+	}
+
+	if err = Verify(src, "i-2", true); err != nil {
+		t.Errorf("Verify() = %v when forced", err)
+	}
+
+	src.err = errors.New("api down")
+	if err = Verify(src, "i-2", false); err != src.err {
+		t.Errorf("Verify() = %v, want the source error", err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	p := &Pin{Holder: "i-1", Reason: "upgrade", Since: time.Unix(1500000000, 0).UTC()}
+
+	got, err := Decode(p.Encode())
+	if err != nil {
+		t.Fatalf("Decode() = %v", err)
+	}
+
+	if *got != *p {
+		t.Errorf("Decode(Encode()) = %+v, want %+v", got, p)
+	}
+}
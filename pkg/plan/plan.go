@@ -0,0 +1,121 @@
+// Package plan describes the route changes a hoster intends to make, so they
+// can be reviewed, saved to a file, then applied as is (if the routes didn't
+// change meanwhile).
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// ActionCreate adds a route to the IP in a table
+	ActionCreate = "create"
+
+	// ActionReplace changes the target of the route to the IP in a table
+	ActionReplace = "replace"
+
+	// ActionDelete removes the route to the IP from a table
+	ActionDelete = "delete"
+
+	// OperationPreempt plans route the IP to an instance
+	OperationPreempt = "preempt"
+
+	// OperationDestroy plans remove the routes to an instance
+	OperationDestroy = "destroy"
+)
+
+// StaleError is returned when applying a plan whose routes changed since it was computed
+type StaleError struct {
+	Reason string
+}
+
+func (e *StaleError) Error() string {
+	return "stale plan: " + e.Reason
+}
+
+// Change is an intended change of the route to the IP in a table (or GCE
+// network). Before and After are the route targets (empty when absent).
+type Change struct {
+	Table  string `json:"table"`
+	Action string `json:"action"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// Plan lists the changes of an operation on the IP, and where the instance
+// is (so the plan can be applied from another host)
+type Plan struct {
+	IP        string    `json:"ip"`
+	Hoster    string    `json:"hoster"`
+	Instance  string    `json:"instance"`
+	Region    string    `json:"region,omitempty"`
+	Project   string    `json:"project,omitempty"`
+	Zone      string    `json:"zone,omitempty"`
+	Operation string    `json:"operation"`
+	Created   time.Time `json:"created"`
+	Changes   []Change  `json:"changes"`
+}
+
+// Save writes the plan to file, as JSON
+func (p *Plan) Save(file string) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, append(b, '\n'), 0644)
+}
+
+// Load reads a plan saved to file
+func Load(file string) (*Plan, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plan{}
+	if err = json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("invalid plan %s: %v", file, err)
+	}
+
+	for _, c := range p.Changes {
+		switch c.Action {
+		case ActionCreate, ActionReplace, ActionDelete:
+		default:
+			return nil, fmt.Errorf("invalid plan %s: unsupported action '%s'", file, c.Action)
+		}
+	}
+
+	return p, nil
+}
+
+// Print displays the plan, one line per change
+func Print(w io.Writer, p *Plan) error {
+	if len(p.Changes) == 0 {
+		_, err := fmt.Fprintf(w, "No changes to %s %s on %s\n", p.Operation, p.IP, p.Instance)
+		return err
+	}
+
+	fmt.Fprintf(w, "Plan to %s %s on %s (%s), %d change(s):\n",
+		p.Operation, p.IP, p.Instance, p.Hoster, len(p.Changes))
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range p.Changes {
+		fmt.Fprintf(tw, "  %s\t%s\t%s -> %s\n", c.Action, c.Table, none(c.Before), none(c.After))
+	}
+
+	return tw.Flush()
+}
+
+func none(target string) string {
+	if target == "" {
+		return "(none)"
+	}
+
+	return target
+}
@@ -0,0 +1,86 @@
+package plan
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := &Plan{
+		IP:        "10.200.0.1",
+		Hoster:    "gce",
+		Instance:  "vm-1",
+		Project:   "project-1",
+		Zone:      "europe-west1-b",
+		Operation: OperationPreempt,
+		Created:   time.Unix(1500000000, 0).UTC(),
+		Changes:   []Change{{Table: "default", Action: ActionCreate, After: "vm-1"}},
+	}
+
+	file := filepath.Join(dir, "plan.json")
+	if err = p.Save(file); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+
+	got, err := Load(file)
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("Load(Save()) = %+v, want %+v", got, p)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, content := range []string{
+		"not json",
+		`{"ip": "10.200.0.1", "changes": [{"table": "rtb-1", "action": "drop"}]}`,
+	} {
+		file := filepath.Join(dir, "plan.json")
+		if err = ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = Load(file); err == nil {
+			t.Errorf("Load() accepted %s", content)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	p := &Plan{
+		IP:        "10.200.0.1",
+		Hoster:    "aws",
+		Instance:  "i-1",
+		Operation: OperationDestroy,
+		Changes:   []Change{{Table: "rtb-1", Action: ActionDelete, Before: "eni-1"}},
+	}
+
+	var buf bytes.Buffer
+	if err := Print(&buf, p); err != nil {
+		t.Fatalf("Print() = %v", err)
+	}
+
+	if out := buf.String(); !strings.Contains(out, "delete  rtb-1  eni-1 -> (none)") {
+		t.Errorf("Print() = %s", out)
+	}
+}
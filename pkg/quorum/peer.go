@@ -0,0 +1,228 @@
+package quorum
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+)
+
+const (
+	witnessPath     = "/witness"
+	signatureHeader = "X-Cfi-Signature"
+	maxBodySize     = 4096
+	maxClockSkew    = 30 * time.Second
+)
+
+// request asks a peer agent whether owner is down
+type request struct {
+	IP    string `json:"ip"`
+	Owner string `json:"owner"`
+	Time  int64  `json:"time"`
+}
+
+// response is a peer agent's vote
+type response struct {
+	Owner  string `json:"owner"`
+	Down   bool   `json:"down"`
+	Reason string `json:"reason"`
+	Time   int64  `json:"time"`
+}
+
+// Judge tells whether owner (as returned by hoster's Owner) is down, and why
+type Judge func(owner string) (down bool, reason string, err error)
+
+// peerWitness asks another cloud-floating-ip daemon (see Server)
+type peerWitness struct {
+	addr   string
+	ip     string
+	key    []byte
+	client *http.Client
+}
+
+func newPeerWitness(addr, ip string, key []byte) *peerWitness {
+	return &peerWitness{addr: addr, ip: ip, key: key, client: &http.Client{}}
+}
+
+func (w *peerWitness) Vote(ctx context.Context, owner string) (bool, string, error) {
+	body, err := json.Marshal(&request{IP: w.ip, Owner: owner, Time: time.Now().UnixNano()})
+	if err != nil {
+		return false, "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+w.addr+witnessPath, bytes.NewReader(body))
+	if err != nil {
+		return false, "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(signatureHeader, sign(w.key, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+
+	payload, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return false, "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return false, "", fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(payload))
+	}
+
+	if err = verify(w.key, payload, resp.Header.Get(signatureHeader)); err != nil {
+		return false, "", err
+	}
+
+	vote := &response{}
+	if err = json.Unmarshal(payload, vote); err != nil {
+		return false, "", fmt.Errorf("invalid response: %v", err)
+	}
+
+	if vote.Owner != owner {
+		return false, "", fmt.Errorf("response is about %s", vote.Owner)
+	}
+
+	if err = checkSkew(vote.Time); err != nil {
+		return false, "", err
+	}
+
+	return vote.Down, vote.Reason, nil
+}
+
+func (w *peerWitness) String() string {
+	return w.addr
+}
+
+// Server answers the peers asking whether an owner is down
+type Server struct {
+	conf  *config.CfiConfig
+	log   log.Logger
+	key   []byte
+	judge Judge
+}
+
+// NewServer returns a witness server, voting as per judge
+func NewServer(conf *config.CfiConfig, logger log.Logger, judge Judge) (*Server, error) {
+	if conf.AuthKey == "" {
+		return nil, errors.New("the witness server requires an auth-key")
+	}
+
+	return &Server{conf: conf, log: logger, key: []byte(conf.AuthKey), judge: judge}, nil
+}
+
+// Serve answers witness requests on conf.WitnessListen, until ctx is cancelled
+func (s *Server) Serve(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(witnessPath, s.handle)
+
+	srv := &http.Server{Addr: s.conf.WitnessListen, Handler: mux, ReadTimeout: witnessTimeout}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = verify(s.key, body, r.Header.Get(signatureHeader)); err != nil {
+		s.log.Errorf("Discarding witness request from %s: %v\n", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	req := &request{}
+	if err = json.Unmarshal(body, req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if err = checkSkew(req.Time); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if req.IP != s.conf.IP {
+		http.Error(w, "not managing "+req.IP, http.StatusNotFound)
+		return
+	}
+
+	down, reason, err := s.judge(req.Owner)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	payload, err := json.Marshal(&response{Owner: req.Owner, Down: down, Reason: reason, Time: time.Now().UnixNano()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.log.Infof("Witness request from %s: %s is down: %v (%s)\n", r.RemoteAddr, req.Owner, down, reason)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(signatureHeader, sign(s.key, payload))
+	_, _ = w.Write(payload)
+}
+
+func sign(key, payload []byte) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verify(key, payload []byte, signature string) error {
+	sum, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("invalid signature")
+	}
+
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
+
+func checkSkew(t int64) error {
+	skew := time.Since(time.Unix(0, t))
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		return fmt.Errorf("timestamp is off by %s", skew)
+	}
+
+	return nil
+}
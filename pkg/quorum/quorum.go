@@ -0,0 +1,170 @@
+// Package quorum asks witnesses (peer agents, or the cloud API) whether the
+// current owner of the floating IP is really down, before we take it over:
+// an instance losing connectivity to the owner might be the isolated one.
+package quorum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/hoster"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+)
+
+const (
+	// WitnessCloud is the witness asking the cloud API for the owner's instance state
+	WitnessCloud = "cloud"
+
+	witnessTimeout = 5 * time.Second
+)
+
+// Witness votes on whether the owner of the IP (as returned by hoster's Owner) is down
+type Witness interface {
+	Vote(ctx context.Context, owner string) (down bool, reason string, err error)
+	String() string
+}
+
+// Checker collects the witnesses votes
+type Checker struct {
+	conf      *config.CfiConfig
+	hoster    hoster.Hoster
+	log       log.Logger
+	witnesses []Witness
+	quorum    int
+}
+
+type vote struct {
+	witness Witness
+	down    bool
+	reason  string
+	err     error
+}
+
+// New returns a checker asking the configured witnesses, and requiring
+// conf.Quorum votes (a majority by default)
+func New(conf *config.CfiConfig, h hoster.Hoster, logger log.Logger) (*Checker, error) {
+	c := &Checker{conf: conf, hoster: h, log: logger, quorum: conf.Quorum}
+
+	for _, w := range conf.Witnesses {
+		if w == WitnessCloud {
+			c.witnesses = append(c.witnesses, &cloudWitness{hoster: h})
+			continue
+		}
+
+		if _, _, err := net.SplitHostPort(w); err != nil {
+			return nil, fmt.Errorf("invalid witness '%s': %v", w, err)
+		}
+
+		if conf.AuthKey == "" {
+			return nil, errors.New("peer witnesses require an auth-key")
+		}
+
+		c.witnesses = append(c.witnesses, newPeerWitness(w, conf.IP, []byte(conf.AuthKey)))
+	}
+
+	if c.quorum <= 0 {
+		c.quorum = len(c.witnesses)/2 + 1
+	}
+
+	if len(c.witnesses) > 0 && c.quorum > len(c.witnesses) {
+		return nil, fmt.Errorf("quorum %d is larger than the number of witnesses (%d)", c.quorum, len(c.witnesses))
+	}
+
+	return c, nil
+}
+
+// Confirm returns nil when a quorum of witnesses agree the current owner is
+// down (or when there's no witness, no owner, or --force was given)
+func (c *Checker) Confirm() error {
+	if len(c.witnesses) == 0 || c.conf.Force || c.hoster.Status() {
+		return nil
+	}
+
+	owner, err := c.hoster.Owner()
+	if err != nil {
+		return fmt.Errorf("failed to get the owner: %v", err)
+	}
+
+	if owner == "" || path.Base(owner) == c.conf.Instance {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), witnessTimeout)
+	defer cancel()
+
+	votes := make(chan vote, len(c.witnesses))
+	for _, w := range c.witnesses {
+		go func(w Witness) {
+			down, reason, err := w.Vote(ctx, owner)
+			votes <- vote{witness: w, down: down, reason: reason, err: err}
+		}(w)
+	}
+
+	downs := 0
+	for range c.witnesses {
+		v := <-votes
+
+		switch {
+		case v.err != nil:
+			c.log.Warnf("Witness %s didn't vote: %v\n", v.witness, v.err)
+		case v.down:
+			downs++
+			c.log.Infof("Witness %s: %s is down (%s)\n", v.witness, owner, v.reason)
+		default:
+			c.log.Infof("Witness %s: %s is up (%s)\n", v.witness, owner, v.reason)
+		}
+	}
+
+	if downs < c.quorum {
+		return fmt.Errorf("no quorum: %d/%d witnesses confirm %s is down, %d needed",
+			downs, len(c.witnesses), owner, c.quorum)
+	}
+
+	return nil
+}
+
+// cloudWitness asks the cloud API whether the owner instance is running
+type cloudWitness struct {
+	hoster hoster.Hoster
+}
+
+// Vote asks the cloud API, giving up when ctx is done (the hoster's API calls
+// don't take a context)
+func (w *cloudWitness) Vote(ctx context.Context, owner string) (bool, string, error) {
+	type result struct {
+		healthy bool
+		err     error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		healthy, err := w.hoster.InstanceHealthy(owner)
+		done <- result{healthy: healthy, err: err}
+	}()
+
+	var r result
+	select {
+	case <-ctx.Done():
+		return false, "", ctx.Err()
+	case r = <-done:
+	}
+
+	if r.err != nil {
+		return false, "", r.err
+	}
+
+	if !r.healthy {
+		return true, "instance isn't running, or is impaired", nil
+	}
+
+	return false, "instance is running", nil
+}
+
+func (w *cloudWitness) String() string {
+	return WitnessCloud
+}
@@ -0,0 +1,126 @@
+package quorum
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/hoster"
+	"github.com/bpineau/cloud-floating-ip/pkg/log/console"
+)
+
+// fakeHoster sees the routes owned by owner, and that instance in the given health
+type fakeHoster struct {
+	hoster.Hoster
+	owner   string
+	healthy bool
+	block   chan struct{}
+}
+
+func (h *fakeHoster) Status() bool { return false }
+
+func (h *fakeHoster) Owner() (string, error) { return h.owner, nil }
+
+func (h *fakeHoster) InstanceHealthy(instance string) (bool, error) {
+	if h.block != nil {
+		<-h.block
+	}
+	return h.healthy, nil
+}
+
+// newPeer returns the address of a witness server voting down (or not)
+func newPeer(t *testing.T, down bool) (string, func()) {
+	conf := &config.CfiConfig{IP: "10.200.0.1", AuthKey: "secret"}
+	judge := func(owner string) (bool, string, error) { return down, "judged", nil }
+
+	srv, err := NewServer(conf, &console.Logger{Quiet: true}, judge)
+	if err != nil {
+		t.Fatalf("NewServer() = %v", err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(srv.handle))
+
+	return strings.TrimPrefix(ts.URL, "http://"), ts.Close
+}
+
+func TestConfirm(t *testing.T) {
+	up, stopUp := newPeer(t, false)
+	defer stopUp()
+	down, stopDown := newPeer(t, true)
+	defer stopDown()
+
+	tests := []struct {
+		name      string
+		witnesses []string
+		quorum    int
+		healthy   bool
+		confirmed bool
+	}{
+		{"no witness", nil, 0, true, true},
+		{"majority down", []string{down, down, up}, 0, true, true},
+		{"majority up", []string{down, up, up}, 0, true, false},
+		{"cloud sees the owner down", []string{WitnessCloud, down}, 0, false, true},
+		{"cloud sees the owner up", []string{WitnessCloud, down}, 0, true, false},
+		{"explicit quorum", []string{down, up, up}, 1, true, true},
+		{"unreachable witness", []string{"127.0.0.1:1", down}, 0, true, false},
+	}
+
+	for _, tt := range tests {
+		conf := &config.CfiConfig{
+			IP:        "10.200.0.1",
+			Instance:  "i-1",
+			AuthKey:   "secret",
+			Witnesses: tt.witnesses,
+			Quorum:    tt.quorum,
+		}
+		h := &fakeHoster{owner: "i-2", healthy: tt.healthy}
+
+		c, err := New(conf, h, &console.Logger{Quiet: true})
+		if err != nil {
+			t.Fatalf("%s: New() = %v", tt.name, err)
+		}
+
+		if err = c.Confirm(); (err == nil) != tt.confirmed {
+			t.Errorf("%s: Confirm() = %v, want confirmed=%v", tt.name, err, tt.confirmed)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	h := &fakeHoster{}
+
+	if _, err := New(&config.CfiConfig{Witnesses: []string{"nohost"}}, h, nil); err == nil {
+		t.Error("New() accepted an invalid witness")
+	}
+
+	if _, err := New(&config.CfiConfig{Witnesses: []string{"10.0.0.1:7000"}}, h, nil); err == nil {
+		t.Error("New() accepted a peer witness without auth-key")
+	}
+
+	if _, err := New(&config.CfiConfig{Witnesses: []string{WitnessCloud}, Quorum: 2}, h, nil); err == nil {
+		t.Error("New() accepted a quorum larger than the witnesses")
+	}
+}
+
+func TestCloudWitnessHonoursContext(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	w := &cloudWitness{hoster: &fakeHoster{block: block}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, _, err := w.Vote(ctx, "i-2"); err != context.DeadlineExceeded {
+		t.Errorf("Vote() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Vote() took %s", elapsed)
+	}
+}
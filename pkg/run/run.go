@@ -1,41 +1,511 @@
 package run
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/daemon"
+	"github.com/bpineau/cloud-floating-ip/pkg/drain"
+	"github.com/bpineau/cloud-floating-ip/pkg/election"
+	"github.com/bpineau/cloud-floating-ip/pkg/health"
 	"github.com/bpineau/cloud-floating-ip/pkg/hoster"
+	"github.com/bpineau/cloud-floating-ip/pkg/inventory"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
 	"github.com/bpineau/cloud-floating-ip/pkg/log/console"
+	"github.com/bpineau/cloud-floating-ip/pkg/log/journald"
+	"github.com/bpineau/cloud-floating-ip/pkg/notify"
 	"github.com/bpineau/cloud-floating-ip/pkg/operation"
+	"github.com/bpineau/cloud-floating-ip/pkg/ownership"
+	"github.com/bpineau/cloud-floating-ip/pkg/pin"
+	"github.com/bpineau/cloud-floating-ip/pkg/plan"
+	"github.com/bpineau/cloud-floating-ip/pkg/quorum"
 )
 
+const (
+	// StatePartial is the status of an IP whose route tables disagree
+	StatePartial = "partial"
+
+	// ExitPartial is the status exit code for a partial state
+	ExitPartial = 3
+
+	// ExitOwnerMismatch is the exit code when the IP isn't owned by the --expect-owner
+	ExitOwnerMismatch = 4
+
+	moveVerifyTimeout  = 30 * time.Second
+	moveVerifyInterval = 2 * time.Second
+)
+
+var errPartial = errors.New("route tables disagree")
+
 // Run launchs the effective operations
 func Run(conf *config.CfiConfig, op operation.CfiOperation) {
 	var err error
 
-	log := &console.Logger{Quiet: conf.Quiet}
+	logger := newLogger(conf, op)
+
+	if op == operation.CfiDaemon {
+		if err = election.ResolveInstance(conf); err != nil {
+			logger.Fatalf("Failed to resolve the target instance: %v\n", err)
+		}
+	}
+
+	if op == operation.CfiMove {
+		// the hoster acts on behalf of the target instance
+		conf.Instance = conf.MoveTo
+
+		if conf.MoveFrom != "" && conf.ExpectOwner != "" && conf.MoveFrom != conf.ExpectOwner {
+			logger.Fatalf("--from and --expect-owner disagree\n")
+		}
+		if conf.ExpectOwner == "" {
+			conf.ExpectOwner = conf.MoveFrom
+		}
+	}
+
+	var pl *plan.Plan
+	if op == operation.CfiApply {
+		if pl, err = plan.Load(conf.PlanFile); err != nil {
+			logger.Fatalf("Failed to load the plan: %v\n", err)
+		}
+
+		if conf.IP != "" && conf.IP != pl.IP {
+			logger.Fatalf("The plan is for %s, not %s\n", pl.IP, conf.IP)
+		}
+		if conf.Hoster != "" && conf.Hoster != pl.Hoster {
+			logger.Fatalf("The plan is for %s, not %s\n", pl.Hoster, conf.Hoster)
+		}
+
+		// the hoster acts on behalf of the planned instance, where it was planned
+		conf.IP, conf.Hoster, conf.Instance = pl.IP, pl.Hoster, pl.Instance
+		conf.Region, conf.Project, conf.Zone = pl.Region, pl.Project, pl.Zone
+	}
 
 	h, err := hoster.GuessHoster(conf.Hoster)
 	if err != nil {
-		log.Fatalf("Can't guess hoster, please specify '-o' option: %v", err)
+		logger.Fatalf("Can't guess hoster, please specify '-o' option: %v", err)
 	}
 
-	h.Init(conf, log)
+	if err = h.Init(conf, logger); err != nil {
+		logger.Fatalf("Failed to initialize %s hoster: %v\n", hoster.Name(h), err)
+	}
+	log.SetField(logger, "CFI_HOSTER", hoster.Name(h))
+
+	notifier := notify.New(conf, hoster.Name(h), logger)
+	previous := ""
+	if conf.Notify && notifier.Enabled() && (op == operation.CfiPreempt || op == operation.CfiDestroy) {
+		previous = state(h.Status())
+	}
 
 	switch op {
 	case operation.CfiPreempt:
-		err = h.Preempt()
+		err = preempt(conf, h, logger)
 	case operation.CfiDestroy:
-		err = h.Destroy()
+		err = destroy(conf, h, drain.New(conf, notifier, logger), logger)
+	case operation.CfiDaemon:
+		var d *daemon.Daemon
+		d, err = daemon.New(conf, h, logger)
+		if err == nil {
+			err = d.Run()
+		}
 	case operation.CfiStatus:
-		if h.Status() {
-			fmt.Println("primary")
+		err = status(conf, h)
+	case operation.CfiPin:
+		err = pinIP(conf, h, logger)
+	case operation.CfiUnpin:
+		err = h.SetPin(nil)
+	case operation.CfiList:
+		err = list(h)
+	case operation.CfiRepair:
+		err = repair(conf, h, logger)
+	case operation.CfiMove:
+		err = move(conf, h, drain.New(conf, notifier, logger), logger)
+	case operation.CfiPlan:
+		err = planChanges(conf, h)
+	case operation.CfiApply:
+		err = apply(conf, h, pl, logger)
+	}
+
+	if err == errPartial {
+		os.Exit(ExitPartial)
+	}
+
+	if _, ok := err.(*ownership.MismatchError); ok {
+		logger.Errorf("%v\n", err)
+		os.Exit(ExitOwnerMismatch)
+	}
+
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	if previous == "" {
+		return
+	}
+
+	if current := state(h.Status()); current != previous {
+		if err = notifier.Run(current, previous); err != nil {
+			logger.Fatalf("Failed to run hook: %v\n", err)
+		}
+	}
+}
+
+// newLogger returns a logger, sending structured messages to journald
+// when the daemon runs as a systemd service
+func newLogger(conf *config.CfiConfig, op operation.CfiOperation) log.Logger {
+	if op != operation.CfiDaemon || !journald.Enabled() {
+		return &console.Logger{Quiet: conf.Quiet}
+	}
+
+	logger := &journald.Logger{Quiet: conf.Quiet}
+	logger.SetField("CFI_IP", conf.IP)
+
+	return logger
+}
+
+// state returns the role matching the routes ownership
+func state(owner bool) string {
+	if owner {
+		return daemon.RolePrimary
+	}
+
+	return daemon.RoleStandby
+}
+
+// ownershipState returns the role matching the routes ownership, or partial
+// when the route tables disagree
+func ownershipState(o *ownership.Ownership) string {
+	if len(o.Divergent()) > 0 {
+		return StatePartial
+	}
+
+	return state(o.Owned())
+}
+
+// preempt takes over the IP, unless the configured health checks fail, the
+// witnesses don't confirm the current owner is down, or the current owner
+// isn't the expected one
+func preempt(conf *config.CfiConfig, h hoster.Hoster, logger log.Logger) error {
+	if err := checkPreempt(conf, h, true, logger); err != nil {
+		return err
+	}
+
+	if err := checkOwner(conf, h); err != nil {
+		return err
+	}
+
+	return h.Preempt()
+}
+
+// checkPreempt returns an error when the configured health checks fail (only
+// run when local, as they probe this host), or the witnesses don't confirm
+// the current owner is down
+func checkPreempt(conf *config.CfiConfig, h hoster.Hoster, local bool, logger log.Logger) error {
+	checker, err := health.NewChecker(conf, logger)
+	if err != nil {
+		return err
+	}
+
+	if !local && checker.Enabled() {
+		logger.Infof("Not running the health checks, %s isn't this instance\n", conf.Instance)
+	} else if !checker.Check() {
+		return fmt.Errorf("health checks failed, not preempting %s", conf.IP)
+	}
+
+	q, err := quorum.New(conf, h, logger)
+	if err != nil {
+		return err
+	}
+
+	if err = q.Confirm(); err != nil {
+		return fmt.Errorf("not preempting %s: %v", conf.IP, err)
+	}
+
+	return nil
+}
+
+// destroy drains the connections, then removes the routes to the instance
+// (all the routes to the IP with --all), unless the IP is pinned or isn't
+// owned by the expected owner. Asks for confirmation on terminals.
+func destroy(conf *config.CfiConfig, h hoster.Hoster, drainer *drain.Drainer, logger log.Logger) error {
+	if err := pin.Verify(h, "", conf.Force); err != nil {
+		return fmt.Errorf("%v, not destroying (use --force to override)", err)
+	}
+
+	if err := checkOwner(conf, h); err != nil {
+		return err
+	}
+
+	o, err := h.Ownership()
+	if err != nil {
+		return err
+	}
+
+	var doomed []string
+	foreign := 0
+	for _, t := range o.Tables {
+		switch {
+		case t.State == ownership.StateAbsent:
+		case t.State == ownership.StateUs || conf.DestroyAll:
+			doomed = append(doomed, t.ID)
+		default:
+			foreign++
+		}
+	}
+
+	if foreign > 0 {
+		logger.Warnf("%d route(s) to %s target other instances, and won't be deleted (use --all to delete them)\n",
+			foreign, conf.IP)
+	}
+
+	if len(doomed) == 0 {
+		logger.Infof("No route to %s to delete\n", conf.IP)
+		return nil
+	}
+
+	if !conf.AssumeYes && !conf.DryRun && interactive() {
+		if !confirm(fmt.Sprintf("Delete the routes to %s from %s?", conf.IP, strings.Join(doomed, ", "))) {
+			return errors.New("destroy aborted")
+		}
+	}
+
+	if o.Owned() {
+		drainer.Drain()
+	}
+
+	return h.Destroy()
+}
+
+// interactive returns true when we can ask the user for confirmations
+func interactive() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// confirm asks a yes/no question on the terminal, no being the default
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
+
+// pinIP pins the IP on the instance, unless another instance holds a pin
+func pinIP(conf *config.CfiConfig, h hoster.Hoster, logger log.Logger) error {
+	if err := pin.Verify(h, conf.Instance, conf.Force); err != nil {
+		return fmt.Errorf("%v (use --force to override)", err)
+	}
+
+	now := time.Now()
+	p := &pin.Pin{Holder: conf.Instance, Reason: conf.PinReason, Since: now}
+	if conf.PinDuration > 0 {
+		p.Expiry = now.Add(conf.PinDuration)
+	}
+
+	if !h.Status() {
+		logger.Warnf("Routes to %s don't target %s, preempt to move the IP there\n", conf.IP, conf.Instance)
+	}
+
+	return h.SetPin(p)
+}
+
+// move routes the IP to the conf.MoveTo instance (h acts on its behalf), when
+// the IP is owned by the expected instance (if given). Connections are
+// drained first when we're the current owner (we can't drain remote ones),
+// and the routes are verified afterwards. The previous owner isn't fenced.
+func move(conf *config.CfiConfig, h hoster.Hoster, drainer *drain.Drainer, logger log.Logger) error {
+	if err := checkOwner(conf, h); err != nil {
+		return err
+	}
+
+	if !h.Status() && drainer.Enabled() {
+		if drainer.Local() {
+			drainer.Drain()
 		} else {
-			fmt.Println("standby")
+			logger.Warnf("Not draining %s: it isn't configured on this host, drain the current owner beforehand\n", conf.IP)
 		}
 	}
 
+	if err := h.Preempt(); err != nil {
+		return err
+	}
+
+	if conf.DryRun {
+		return nil
+	}
+
+	deadline := time.Now().Add(moveVerifyTimeout)
+	for {
+		o, err := h.Ownership()
+		if err != nil {
+			return fmt.Errorf("failed to verify the move: %v", err)
+		}
+
+		if o.Owned() {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("routes to %s still don't all target %s after the move (divergent: %s)",
+				conf.IP, conf.MoveTo, strings.Join(o.Divergent(), ","))
+		}
+
+		time.Sleep(moveVerifyInterval)
+	}
+}
+
+// planChanges displays the route changes a preempt (or destroy) would make,
+// and saves them to conf.PlanOut (when given)
+func planChanges(conf *config.CfiConfig, h hoster.Hoster) error {
+	if err := checkOwner(conf, h); err != nil {
+		return err
+	}
+
+	pl, err := h.Plan(conf.PlanDestroy)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	pl.Hoster = hoster.Name(h)
+	pl.Region, pl.Project, pl.Zone = conf.Region, conf.Project, conf.Zone
+	pl.Created = time.Now()
+
+	if err = plan.Print(os.Stdout, pl); err != nil {
+		return err
+	}
+
+	if conf.PlanOut == "" {
+		return nil
+	}
+
+	if err = pl.Save(conf.PlanOut); err != nil {
+		return fmt.Errorf("failed to save the plan: %v", err)
 	}
+
+	return nil
+}
+
+// apply makes the route changes of a saved plan, unless a pin forbids them
+// (any active pin for a destroy, or another instance's pin for a preempt), or
+// the witnesses (and the health checks, when applied on the planned
+// instance) would forbid the planned preempt. Fencing and draining aren't
+// applied.
+func apply(conf *config.CfiConfig, h hoster.Hoster, pl *plan.Plan, logger log.Logger) error {
+	if len(pl.Changes) == 0 {
+		logger.Infof("Nothing to apply, the plan has no changes\n")
+		return nil
+	}
+
+	// any pin forbids deleting the routes
+	instance := pl.Instance
+	if pl.Operation == plan.OperationDestroy {
+		instance = ""
+	}
+
+	if err := pin.Verify(h, instance, conf.Force); err != nil {
+		return fmt.Errorf("%v, not applying (use --force to override)", err)
+	}
+
+	if pl.Operation == plan.OperationPreempt {
+		// the health checks only tell about the host we run on
+		here, err := h.LocalInstance()
+		local := err == nil && here == pl.Instance
+
+		if err = checkPreempt(conf, h, local, logger); err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("Applying the %s plan of %s, computed %s\n", pl.Operation, pl.IP,
+		pl.Created.Format(time.RFC3339))
+
+	return h.Apply(pl)
+}
+
+// checkOwner returns a *ownership.MismatchError unless the IP is owned by
+// conf.ExpectOwner (when given) in all the tables. Hosters check each route
+// again right before changing it.
+func checkOwner(conf *config.CfiConfig, h hoster.Hoster) error {
+	if conf.ExpectOwner == "" {
+		return nil
+	}
+
+	o, err := h.Ownership()
+	if err != nil {
+		return err
+	}
+
+	return o.Expect(conf.ExpectOwner)
+}
+
+// list displays all the floating IPs routes
+func list(h hoster.Hoster) error {
+	entries, err := h.List()
+	if err != nil {
+		return err
+	}
+
+	return inventory.Print(os.Stdout, entries)
+}
+
+// status displays the instance's role, who owns the IP (and how), and the
+// active pin if any. Returns errPartial when the route tables disagree.
+func status(conf *config.CfiConfig, h hoster.Hoster) error {
+	o, err := h.Ownership()
+	if err != nil {
+		return err
+	}
+
+	p, err := h.GetPin()
+	if err != nil {
+		return err
+	}
+
+	if !p.Active(time.Now()) {
+		p = nil
+	}
+
+	st := ownershipState(o)
+
+	if conf.JSON {
+		err = json.NewEncoder(os.Stdout).Encode(struct {
+			State string `json:"state"`
+			*ownership.Ownership
+			Divergent []string `json:"divergent,omitempty"`
+			Pin       *pin.Pin `json:"pin,omitempty"`
+		}{st, o, o.Divergent(), p})
+	} else {
+		fmt.Println(st)
+		err = ownership.Print(os.Stdout, o)
+		if err == nil && p != nil {
+			fmt.Printf("pinned %s\n", p)
+		}
+	}
+
+	if err == nil && st == StatePartial {
+		return errPartial
+	}
+
+	return err
+}
+
+// repair makes all the route tables agree on the majority target, or on the
+// conf.RepairOwner instance
+func repair(conf *config.CfiConfig, h hoster.Hoster, logger log.Logger) error {
+	if conf.RepairOwner == "" {
+		return h.Repair()
+	}
+
+	owner, err := hoster.ForInstance(h, conf, conf.RepairOwner, logger)
+	if err != nil {
+		return fmt.Errorf("failed to prepare the routes to %s: %v", conf.RepairOwner, err)
+	}
+
+	return owner.Preempt()
 }
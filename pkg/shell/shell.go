@@ -0,0 +1,54 @@
+// Package shell runs user shell commands (hooks, health and drain probes)
+// so that a timeout can't be stalled by their children.
+package shell
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Run runs command with /bin/sh, our environment and env, until it exits or
+// ctx is done. It runs in its own process group, all killed when ctx is done.
+// Its output goes to a file rather than a pipe, so we don't wait for
+// background processes holding the output open. Returns what the command
+// wrote to stdout, and to stderr unless stderr is given.
+func Run(ctx context.Context, command string, env []string, stderr *os.File) ([]byte, error) {
+	out, err := ioutil.TempFile("", "cloud-floating-ip-shell")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	if stderr == nil {
+		stderr = out
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = out
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		err = ctx.Err()
+	}
+
+	output, _ := ioutil.ReadFile(out.Name())
+
+	return output, err
+}
@@ -0,0 +1,51 @@
+package shell
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		out     string
+		fails   bool
+	}{
+		{"success", "echo ok; echo err >&2", "ok\nerr\n", false},
+		{"environment", "echo $CFI_IP", "10.200.0.1\n", false},
+		{"failure", "echo failed; exit 3", "failed\n", true},
+		{"timeout", "echo slow; sleep 10", "slow\n", true},
+		{"background process killed on timeout", "sleep 10 & wait", "", true},
+	}
+
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+
+		start := time.Now()
+		out, err := Run(ctx, tt.command, []string{"CFI_IP=10.200.0.1"}, nil)
+		cancel()
+
+		if (err != nil) != tt.fails || string(out) != tt.out {
+			t.Errorf("%s: Run() = '%s', %v", tt.name, out, err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: Run() took %s", tt.name, elapsed)
+		}
+	}
+}
+
+func TestRunStderr(t *testing.T) {
+	devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devnull.Close()
+
+	out, err := Run(context.Background(), "echo 42; echo noise >&2", nil, devnull)
+	if err != nil || string(out) != "42\n" {
+		t.Errorf("Run() = '%s', %v, want only stdout", out, err)
+	}
+}
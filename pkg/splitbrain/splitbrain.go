@@ -0,0 +1,149 @@
+// Package splitbrain compares the floating IP configured on local interfaces
+// (as seen through netlink) with the cloud routes, and with the peers reports,
+// to flag instances disagreeing about who carries the IP.
+package splitbrain
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/log"
+)
+
+const (
+	// ActionDropVIP removes the IP from local interfaces, when the routes don't target us
+	ActionDropVIP = "drop-vip"
+
+	// ActionPreempt preempts the routes again, when we should be primary
+	ActionPreempt = "preempt"
+
+	// ActionAlert runs the alert command
+	ActionAlert = "alert"
+)
+
+// Detector checks the local VIP state against the routes and the peers
+type Detector struct {
+	conf *config.CfiConfig
+	log  log.Logger
+	ip   net.IP
+}
+
+// New returns a split-brain detector
+func New(conf *config.CfiConfig, logger log.Logger) (*Detector, error) {
+	ip := net.ParseIP(conf.IP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP: '%s'", conf.IP)
+	}
+
+	for _, action := range conf.SplitBrainActions {
+		switch action {
+		case ActionDropVIP, ActionPreempt, ActionAlert:
+		default:
+			return nil, fmt.Errorf("unsupported split-brain action: '%s'", action)
+		}
+	}
+
+	return &Detector{conf: conf, log: logger, ip: ip}, nil
+}
+
+// Check returns the problems found, given the routes ownership and the peers claiming it
+func (d *Detector) Check(owner bool, peers []string) ([]string, error) {
+	local, err := d.LocalVIP()
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+
+	if local && !owner {
+		problems = append(problems, fmt.Sprintf("%s is configured locally, but routes target another instance", d.conf.IP))
+	}
+
+	if owner && !local {
+		problems = append(problems, fmt.Sprintf("routes to %s target this instance, but it isn't configured locally", d.conf.IP))
+	}
+
+	if len(peers) > 0 && (owner || local) {
+		problems = append(problems, fmt.Sprintf("%s also claim %s", strings.Join(peers, ", "), d.conf.IP))
+	}
+
+	return problems, nil
+}
+
+// LocalVIP returns true when the IP is configured on a local interface (on VIPInterface, when set)
+func (d *Detector) LocalVIP() (bool, error) {
+	addrs, err := d.addrs()
+	if err != nil {
+		return false, err
+	}
+
+	return len(addrs) > 0, nil
+}
+
+// DropVIP removes the IP from local interfaces
+func (d *Detector) DropVIP() error {
+	addrs, err := d.addrs()
+	if err != nil {
+		return err
+	}
+
+	for _, a := range addrs {
+		name := a.link.Attrs().Name
+		d.log.Infof("Removing %s from %s\n", a.addr.IPNet, name)
+
+		if d.conf.DryRun {
+			continue
+		}
+
+		addr := a.addr
+		if err := netlink.AddrDel(a.link, &addr); err != nil {
+			return fmt.Errorf("failed to remove %s from %s: %v", addr.IPNet, name, err)
+		}
+	}
+
+	return nil
+}
+
+// linkAddr is a local address, and the interface holding it
+type linkAddr struct {
+	link netlink.Link
+	addr netlink.Addr
+}
+
+// addrs returns the local addresses matching the IP
+func (d *Detector) addrs() ([]linkAddr, error) {
+	var links []netlink.Link
+	if d.conf.VIPInterface != "" {
+		link, err := netlink.LinkByName(d.conf.VIPInterface)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get interface %s: %v", d.conf.VIPInterface, err)
+		}
+		links = append(links, link)
+	} else {
+		var err error
+		links, err = netlink.LinkList()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list interfaces: %v", err)
+		}
+	}
+
+	var addrs []linkAddr
+	for _, link := range links {
+		all, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s addresses: %v", link.Attrs().Name, err)
+		}
+
+		for _, addr := range all {
+			if addr.IPNet != nil && addr.IP.Equal(d.ip) {
+				addrs = append(addrs, linkAddr{link: link, addr: addr})
+			}
+		}
+	}
+
+	return addrs, nil
+}
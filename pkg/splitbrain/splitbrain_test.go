@@ -0,0 +1,52 @@
+package splitbrain
+
+import (
+	"testing"
+
+	"github.com/bpineau/cloud-floating-ip/config"
+	"github.com/bpineau/cloud-floating-ip/pkg/log/console"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		owner    bool
+		peers    []string
+		problems int
+	}{
+		{"local and owner", "127.0.0.1", true, nil, 0},
+		{"local but not owner", "127.0.0.1", false, nil, 1},
+		{"owner but not local", "192.0.2.1", true, nil, 1},
+		{"neither local nor owner", "192.0.2.1", false, nil, 0},
+		{"peers also claim", "127.0.0.1", true, []string{"i-2"}, 1},
+		{"peers claim while we don't", "192.0.2.1", false, []string{"i-2"}, 0},
+	}
+
+	for _, tt := range tests {
+		d, err := New(&config.CfiConfig{IP: tt.ip, VIPInterface: "lo"}, &console.Logger{Quiet: true})
+		if err != nil {
+			t.Fatalf("New() = %v", err)
+		}
+
+		problems, err := d.Check(tt.owner, tt.peers)
+		if err != nil {
+			t.Skipf("can't list local addresses: %v", err)
+		}
+
+		if len(problems) != tt.problems {
+			t.Errorf("%s: Check() = %v, want %d problem(s)", tt.name, problems, tt.problems)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(&config.CfiConfig{IP: "not an IP"}, &console.Logger{Quiet: true}); err == nil {
+		t.Error("New() accepted an invalid IP")
+	}
+
+	conf := &config.CfiConfig{IP: "10.200.0.1", SplitBrainActions: []string{ActionDropVIP, "reboot"}}
+	if _, err := New(conf, &console.Logger{Quiet: true}); err == nil {
+		t.Error("New() accepted an unsupported action")
+	}
+}